@@ -3,14 +3,25 @@ package whiteboard
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
-	"strings"
+	"sync"
 
 	"github.com/traefik/yaegi/interp"
 )
 
-var precedence = map[string]int{"+": 20, "-": 20, "*": 40, "/": 40, "%": 40, "^": 60}
+var precedence = map[string]int{
+	"?":  2,
+	"??": 4,
+	"||": 5,
+	"&&": 6,
+	"==": 10, "!=": 10,
+	"<": 15, "<=": 15, ">": 15, ">=": 15,
+	"+": 20, "-": 20,
+	"*": 40, "/": 40, "%": 40,
+	"^": 60,
+}
 
 type ExprAST interface {
 	toStr() string
@@ -25,20 +36,51 @@ type StrExprAST struct {
 	Str string
 }
 
+type BoolExprAST struct {
+	Val bool
+}
+
+type NullExprAST struct{}
+
+// Offset of the nodes below is the position of their operator or name in the source,
+// it locates evaluation errors
 type BinaryExprAST struct {
 	Op string
 	Lhs,
 	Rhs ExprAST
+	Offset int
+}
+
+type TernaryExprAST struct {
+	Cond,
+	Then,
+	Else ExprAST
+	Offset int
+}
+
+type UnaryExprAST struct {
+	Op      string
+	Operand ExprAST
+	Offset  int
 }
 
 type FunCallerExprAST struct {
-	Name string
-	Arg  []ExprAST
+	Name   string
+	Arg    []ExprAST
+	Offset int
 }
 
 type SelectorExprAST struct {
 	Name     string
 	Selector Selector
+	Offset   int
+}
+
+// VariableExprAST reads the variable Name, such as `$root`, and follows Path into its value
+type VariableExprAST struct {
+	Name   string
+	Path   []interface{}
+	Offset int
 }
 
 func (n NumberExprAST) toStr() string {
@@ -55,6 +97,17 @@ func (n StrExprAST) toStr() string {
 	)
 }
 
+func (b BoolExprAST) toStr() string {
+	return fmt.Sprintf(
+		"BoolExprAST:%t",
+		b.Val,
+	)
+}
+
+func (n NullExprAST) toStr() string {
+	return "NullExprAST:null"
+}
+
 func (b BinaryExprAST) toStr() string {
 	return fmt.Sprintf(
 		"BinaryExprAST: (%s %s %s)",
@@ -64,6 +117,23 @@ func (b BinaryExprAST) toStr() string {
 	)
 }
 
+func (t TernaryExprAST) toStr() string {
+	return fmt.Sprintf(
+		"TernaryExprAST: (%s ? %s : %s)",
+		t.Cond.toStr(),
+		t.Then.toStr(),
+		t.Else.toStr(),
+	)
+}
+
+func (u UnaryExprAST) toStr() string {
+	return fmt.Sprintf(
+		"UnaryExprAST: (%s %s)",
+		u.Op,
+		u.Operand.toStr(),
+	)
+}
+
 func (n FunCallerExprAST) toStr() string {
 	return fmt.Sprintf(
 		"FunCallerExprAST:%s",
@@ -72,9 +142,23 @@ func (n FunCallerExprAST) toStr() string {
 }
 
 func (s SelectorExprAST) toStr() string {
+	switch sel := s.Selector.(type) {
+	case *S:
+		return fmt.Sprintf("SelectorExprAST:S%v", sel.Path)
+	case *K:
+		return fmt.Sprintf("SelectorExprAST:K(%v)", sel.Value)
+	}
+	return fmt.Sprintf(
+		"SelectorExprAST:%s",
+		s.Name,
+	)
+}
+
+func (v VariableExprAST) toStr() string {
 	return fmt.Sprintf(
-		"SelectorExprAST:%s(%v)",
-		s.Name, s.Selector,
+		"VariableExprAST:%s%v",
+		v.Name,
+		v.Path,
 	)
 }
 
@@ -95,7 +179,7 @@ func NewAST(toks []*Token, s string) *AST {
 		source: s,
 	}
 	if a.Tokens == nil || len(a.Tokens) == 0 {
-		a.Err = errors.New("empty token")
+		a.Err = syntaxErrorf(s, 0, "empty token")
 	} else {
 		a.currIndex = 0
 		a.currTok = a.Tokens[0]
@@ -110,9 +194,8 @@ func (a *AST) ParseExpression() ExprAST {
 	r := a.parseBinOpRHS(0, lhs)
 	a.depth--
 	if a.depth == 0 && a.currIndex != len(a.Tokens) && a.Err == nil {
-		a.Err = errors.New(
-			fmt.Sprintf("bad expression, reaching the end or missing the operator\n%s",
-				ErrPos(a.source, a.currTok.Offset)))
+		a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+			"bad expression, reaching the end or missing the operator")
 	}
 	return r
 }
@@ -130,6 +213,9 @@ func (a *AST) getNextToken() *Token {
 // Get the operation priority
 func (a *AST) getTokPrecedence() int {
 	// fmt.Printf("getTokPrecedence-->%v\n", a.currTok.Tok)
+	if a.currTok.Type != Operator {
+		return -1
+	}
 	if p, ok := precedence[a.currTok.Tok]; ok {
 		return p
 	}
@@ -140,11 +226,10 @@ func (a *AST) getTokPrecedence() int {
 func (a *AST) parseNumber() NumberExprAST {
 	f64, err := strconv.ParseFloat(a.currTok.Tok, 64)
 	if err != nil {
-		a.Err = errors.New(
-			fmt.Sprintf("%v\nwant '(' or '0-9' but get '%s'\n%s",
-				err.Error(),
-				a.currTok.Tok,
-				ErrPos(a.source, a.currTok.Offset)))
+		a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+			"%v\nwant '(' or '0-9' but get '%s'",
+			err.Error(),
+			a.currTok.Tok)
 		return NumberExprAST{}
 	}
 	n := NumberExprAST{
@@ -158,12 +243,15 @@ func (a *AST) parseNumber() NumberExprAST {
 func (a *AST) parseFunction() SelectorExprAST {
 
 	name := a.currTok.Tok
+	offset := a.currTok.Offset
 	// fmt.Printf("parseFunction-->%v\n", name)
 
 	selectorType := name
 	a.getNextToken()
 	// call custom function
 	exprs := make([]ExprAST, 0)
+	// where each argument starts, to point syntax errors at it
+	argOffsets := make([]int, 0)
 	if a.currTok.Tok == "(" {
 
 		a.getNextToken()
@@ -172,62 +260,66 @@ func (a *AST) parseFunction() SelectorExprAST {
 			// function call without parameters
 			// ignore the process of parameter resolution
 		} else {
+			argOffsets = append(argOffsets, a.currTok.Offset)
 			exprs = append(exprs, a.ParseExpression())
-			for a.currTok.Tok != ")" && a.getNextToken() != nil {
+			for a.Err == nil && a.currTok.Tok != ")" && a.getNextToken() != nil {
 				if a.currTok.Type == COMMA {
 					continue
 				}
+				argOffsets = append(argOffsets, a.currTok.Offset)
 				exprs = append(exprs, a.ParseExpression())
 			}
 		}
 	}
 	// fmt.Printf("parms-->%v\n", exprs)
+	if a.Err != nil {
+		// an unterminated call such as `S(` leaves a nil argument behind
+		return SelectorExprAST{Name: name, Offset: offset}
+	}
 
 	var ifaceSlice []interface{}
 	var err error
 
 	for _, part := range exprs {
-		fmt.Printf("%v-->%v-->%v\n", part, reflect.TypeOf(part).Kind(), reflect.TypeOf(part).Name())
+		logger.Debugf("selector %s argument %T: %s", name, part, part.toStr())
 		switch part.(type) {
 		case StrExprAST:
 			ifaceSlice = append(ifaceSlice, part.(StrExprAST).Str)
 		case NumberExprAST:
-			ps := part.(NumberExprAST).Str
-			if strings.Contains(ps, ".") {
-				pf, _ := strconv.ParseFloat(ps, 64)
-				ifaceSlice = append(ifaceSlice, pf)
-			} else {
-				pf, _ := strconv.ParseInt(ps, 10, 64)
-				ifaceSlice = append(ifaceSlice, pf)
-			}
+			ifaceSlice = append(ifaceSlice, literalValue(part.(NumberExprAST)).Interface())
+		case BoolExprAST:
+			ifaceSlice = append(ifaceSlice, part.(BoolExprAST).Val)
+		case NullExprAST:
+			ifaceSlice = append(ifaceSlice, nil)
 		case SelectorExprAST:
 			ifaceSlice = append(ifaceSlice, part.(SelectorExprAST).Selector)
+		case BinaryExprAST, UnaryExprAST, TernaryExprAST, FunCallerExprAST, VariableExprAST:
+			// composite arguments such as `S("age") >= 18` are evaluated lazily
+			ifaceSlice = append(ifaceSlice, &exprSelector{expr: part})
 		}
 	}
 
 	// fmt.Printf("%s", ifaceSlice)
-	s := SelectorExprAST{}
+	s := SelectorExprAST{Offset: offset}
 	switch selectorType {
 	case "K":
 		if len(ifaceSlice) == 1 {
 			s.Name = selectorType
 			s.Selector, _ = NewK(ifaceSlice[0])
 		} else {
-			a.Err = errors.New(
-				fmt.Sprintf("Selector `%s` is out of limit\n%s",
-					s.Name,
-					ErrPos(a.source, a.currTok.Offset)))
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` is out of limit",
+				s.Name)
 		}
 
 	case "S":
 		s.Name = selectorType
 		s.Selector, err = NewS(ifaceSlice[0:]...)
 		if err != nil {
-			a.Err = errors.New(
-				fmt.Sprintf("Selector `%s` %s \n%s",
-					s.Name,
-					err.Error(),
-					ErrPos(a.source, a.currTok.Offset)))
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` %s",
+				s.Name,
+				err.Error())
 		}
 
 	case "F":
@@ -236,33 +328,266 @@ func (a *AST) parseFunction() SelectorExprAST {
 		// expr, err := eval.Parse(ifaceSlice[0])
 		// program, err := expr.Compile(parts[0], expr.Env(Env{}))
 
-		i := interp.New(interp.Options{})
-		v, err := i.Eval(ifaceSlice[0].(string))
+		if len(ifaceSlice) == 0 {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants the source of a function",
+				s.Name)
+			break
+		}
+		code, _ := ifaceSlice[0].(string)
+		fn, err := evalFunc(code)
 		if err != nil {
-			a.Err = errors.New(
-				fmt.Sprintf("Selector `%s` %s \n%s",
-					s.Name,
-					err.Error(),
-					ErrPos(a.source, a.currTok.Offset)))
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` %s",
+				s.Name,
+				err.Error())
+			break
 		}
-		fn := v.Interface().(func(interface{}, ...interface{}) interface{})
-		s.Selector = NewF(fn, ifaceSlice[1:]...)
+		// calls into one interpreter are serialized so that a compiled mapping can be shared by goroutines
+		var mu sync.Mutex
+		s.Selector = NewF(func(value interface{}, args ...interface{}) interface{} {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(value, args...)
+		}, ifaceSlice[1:]...)
 	case "ExpS":
 		s.Name = selectorType
-		s.Selector = NewExpressionSelector(ifaceSlice[0].(Selector), ifaceSlice[1].(Selector), ifaceSlice[2].(string))
+		var left, right Selector
+		var op string
+		ok := len(ifaceSlice) == 3
+		if ok {
+			left, ok = ifaceSlice[0].(Selector)
+		}
+		if ok {
+			right, ok = ifaceSlice[1].(Selector)
+		}
+		if ok {
+			op, ok = ifaceSlice[2].(string)
+		}
+		if !ok {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants a selector, a selector and an operator string",
+				s.Name)
+			break
+		}
+		s.Selector = NewExpressionSelector(left, right, op)
 
-	case "IF":
+	case "JP":
 		s.Name = selectorType
-		s.Selector = NewIf(ifaceSlice[0].(Selector), ifaceSlice[1].(Selector), ifaceSlice[2].(Selector))
-	case "AL":
+		path, ok := "", len(ifaceSlice) == 1
+		if ok {
+			path, ok = ifaceSlice[0].(string)
+		}
+		if !ok {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants a single JSONPath string",
+				s.Name)
+			break
+		}
+		s.Selector, err = NewJSONPath(path)
+		if err != nil {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` %s",
+				s.Name,
+				err.Error())
+		}
+
+	case "JMES":
+		s.Name = selectorType
+		expression, ok := "", len(ifaceSlice) == 1
+		if ok {
+			expression, ok = ifaceSlice[0].(string)
+		}
+		if !ok {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants a single JMESPath string",
+				s.Name)
+			break
+		}
+		s.Selector, err = NewJMES(expression)
+		if err != nil {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` %s",
+				s.Name,
+				err.Error())
+		}
+
+	case "IF", "if":
+		s.Name = selectorType
+		if len(ifaceSlice) != 2 && len(ifaceSlice) != 3 {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants 2 or 3 parameters but get %d",
+				s.Name,
+				len(ifaceSlice))
+			break
+		}
+		var whenFalse Selector
+		if len(ifaceSlice) == 3 {
+			whenFalse = toSelector(ifaceSlice[2])
+		}
+		s.Selector = NewIf(toSelector(ifaceSlice[0]), toSelector(ifaceSlice[1]), whenFalse)
+	case "SW", "sw":
 		s.Name = selectorType
-		var selectors []Selector
-		for _, s := range ifaceSlice {
-			if sel, ok := s.(Selector); ok {
-				selectors = append(selectors, sel)
+		// SW(key, case, value, case, value, ..., default)
+		if len(ifaceSlice) < 3 {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants a key and at least one case but get %d parameters",
+				s.Name,
+				len(ifaceSlice))
+			break
+		}
+		cases := make(map[interface{}]Selector)
+		for i := 1; i+1 < len(ifaceSlice); i += 2 {
+			value, ok := literalArg(ifaceSlice[i])
+			if !ok {
+				a.Err = syntaxErrorf(a.source, argOffsets[i],
+					"Selector `%s` wants literal case values but get a selector for case %d",
+					s.Name,
+					(i+1)/2)
+				break
 			}
+			cases[value] = toSelector(ifaceSlice[i+1])
+		}
+		if a.Err != nil {
+			break
+		}
+		var defaultSelector Selector
+		if len(ifaceSlice)%2 == 0 {
+			defaultSelector = toSelector(ifaceSlice[len(ifaceSlice)-1])
+		}
+		s.Selector = NewSwitch(toSelector(ifaceSlice[0]), cases, defaultSelector)
+	case "AL", "al":
+		s.Name = selectorType
+		if !a.checkArgc(s.Name, len(ifaceSlice), 1, -1) {
+			break
+		}
+		selectors := make([]Selector, len(ifaceSlice))
+		for i, arg := range ifaceSlice {
+			selectors[i] = toSelector(arg)
 		}
 		s.Selector = NewAlternation(selectors...)
+	case "MAP", "map", "FILTER", "filter", "FORALL", "forall":
+		s.Name = selectorType
+		if len(ifaceSlice) != 2 {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants 2 parameters but get %d",
+				s.Name,
+				len(ifaceSlice))
+			break
+		}
+		// the item mapping of a mapping string is a selector, which always compiles
+		switch selectorType {
+		case "MAP", "map":
+			s.Selector, _ = NewMap(toSelector(ifaceSlice[0]), toSelector(ifaceSlice[1]))
+		case "FORALL", "forall":
+			s.Selector, _ = NewForAll(toSelector(ifaceSlice[0]), toSelector(ifaceSlice[1]))
+		default:
+			s.Selector, _ = NewFilter(toSelector(ifaceSlice[0]), toSelector(ifaceSlice[1]))
+		}
+	case "REDUCE", "reduce":
+		s.Name = selectorType
+		if len(ifaceSlice) != 3 {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants 3 parameters but get %d",
+				s.Name,
+				len(ifaceSlice))
+			break
+		}
+		s.Selector, _ = NewReduce(toSelector(ifaceSlice[0]), ifaceSlice[1], toSelector(ifaceSlice[2]))
+	case "TRY", "try":
+		s.Name = selectorType
+		if len(ifaceSlice) != 2 {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants 2 parameters but get %d",
+				s.Name,
+				len(ifaceSlice))
+			break
+		}
+		s.Selector = NewTry(toSelector(ifaceSlice[0]), toSelector(ifaceSlice[1]))
+	case "DEFAULT", "default":
+		s.Name = selectorType
+		if len(ifaceSlice) != 2 {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants 2 parameters but get %d",
+				s.Name,
+				len(ifaceSlice))
+			break
+		}
+		value, ok := literalArg(ifaceSlice[1])
+		if !ok {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants a literal default value but get a selector",
+				s.Name)
+			break
+		}
+		s.Selector = NewDefault(toSelector(ifaceSlice[0]), value)
+	case "SORTBY", "sortby":
+		s.Name = selectorType
+		if !a.checkArgc(s.Name, len(ifaceSlice), 2, 3) {
+			break
+		}
+		desc := false
+		if len(ifaceSlice) == 3 {
+			v, ok := literalArg(ifaceSlice[2])
+			if desc, ok = v.(bool); !ok {
+				a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+					"Selector `%s` wants a literal bool to sort in descending order",
+					s.Name)
+				break
+			}
+		}
+		s.Selector = NewSortBy(toSelector(ifaceSlice[0]), toSelector(ifaceSlice[1]), desc)
+	case "GROUPBY", "groupby":
+		s.Name = selectorType
+		if a.checkArgc(s.Name, len(ifaceSlice), 2, 2) {
+			s.Selector = NewGroupBy(toSelector(ifaceSlice[0]), toSelector(ifaceSlice[1]))
+		}
+	case "UNIQUE", "unique":
+		s.Name = selectorType
+		if !a.checkArgc(s.Name, len(ifaceSlice), 1, 2) {
+			break
+		}
+		var key Selector
+		if len(ifaceSlice) == 2 {
+			key = toSelector(ifaceSlice[1])
+		}
+		s.Selector = NewUnique(toSelector(ifaceSlice[0]), key)
+	case "FLATTEN", "flatten":
+		s.Name = selectorType
+		if !a.checkArgc(s.Name, len(ifaceSlice), 1, 2) {
+			break
+		}
+		depth := []int{1}
+		if len(ifaceSlice) == 2 && !a.intArgs(s.Name, ifaceSlice[1:], depth) {
+			break
+		}
+		s.Selector = NewFlatten(toSelector(ifaceSlice[0]), depth[0])
+	case "ZIP", "zip":
+		s.Name = selectorType
+		if !a.checkArgc(s.Name, len(ifaceSlice), 1, -1) {
+			break
+		}
+		lists := make([]Selector, len(ifaceSlice))
+		for i, arg := range ifaceSlice {
+			lists[i] = toSelector(arg)
+		}
+		s.Selector = NewZip(lists...)
+	case "SLICE", "slice":
+		s.Name = selectorType
+		// SLICE(list, start, [end])
+		if !a.checkArgc(s.Name, len(ifaceSlice), 2, 3) {
+			break
+		}
+		bounds := []int{0, math.MaxInt}
+		if !a.intArgs(s.Name, ifaceSlice[1:], bounds) {
+			break
+		}
+		s.Selector = NewSlice(toSelector(ifaceSlice[0]), bounds[0], bounds[1])
+	case "COUNT", "count":
+		s.Name = selectorType
+		if a.checkArgc(s.Name, len(ifaceSlice), 1, 1) {
+			s.Selector = NewCount(toSelector(ifaceSlice[0]))
+		}
 	}
 
 	// fmt.Printf("parseSelector-->%v\n", s)
@@ -271,17 +596,94 @@ func (a *AST) parseFunction() SelectorExprAST {
 	return s
 }
 
+// evalFunc interprets the source of an `F` selector function
+func evalFunc(code string) (func(interface{}, ...interface{}) interface{}, error) {
+	// yaegi does not hand back a bare function literal, so it is bound to a name first
+	for n, src := range []string{code, "fn := " + code + "\nfn"} {
+		i := interp.New(interp.Options{})
+		v, err := i.Eval(src)
+		if err != nil && n == 0 {
+			return nil, err
+		}
+		if err != nil || !v.IsValid() {
+			continue
+		}
+		if fn, ok := v.Interface().(func(interface{}, ...interface{}) interface{}); ok {
+			return fn, nil
+		}
+	}
+	return nil, errors.New("want a func(interface{}, ...interface{}) interface{}")
+}
+
+// toSelector lets a literal parameter stand in for `K(...)` where a selector is expected
+func toSelector(v interface{}) Selector {
+	if s, ok := v.(Selector); ok {
+		return s
+	}
+	k, _ := NewK(v)
+	return k
+}
+
+// literalArg reads a constant parameter: a literal, a K or an expression of literals such as `-1`
+func literalArg(v interface{}) (interface{}, bool) {
+	switch s := v.(type) {
+	case *K:
+		return s.Value, true
+	case *exprSelector:
+		// an expression reading the source fails without one
+		r, err := ExprASTResultWithContext(s.expr, nil)
+		return r, err == nil
+	case Selector:
+		return nil, false
+	}
+	return v, true
+}
+
+// checkArgc reports a syntax error unless selector name gets min to max parameters, max < 0 is unbounded
+func (a *AST) checkArgc(name string, argc, min, max int) bool {
+	if argc >= min && (max < 0 || argc <= max) {
+		return true
+	}
+	want := fmt.Sprintf("%d to %d", min, max)
+	switch {
+	case min == max:
+		want = strconv.Itoa(min)
+	case max < 0:
+		want = fmt.Sprintf("at least %d", min)
+	}
+	a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+		"Selector `%s` wants %s parameters but get %d",
+		name, want, argc)
+	return false
+}
+
+// intArgs reads the literal integer parameters args into dst
+func (a *AST) intArgs(name string, args []interface{}, dst []int) bool {
+	for i, arg := range args {
+		v, ok := literalArg(arg)
+		n, exact := exactInt(reflect.ValueOf(v))
+		if !ok || !exact {
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"Selector `%s` wants a literal integer but get %v",
+				name, arg)
+			return false
+		}
+		dst[i] = int(n)
+	}
+	return true
+}
+
 func (a *AST) parseFunCallerOrConst() ExprAST {
 	name := a.currTok.Tok
+	offset := a.currTok.Offset
 	a.getNextToken()
 	// call func
 	if a.currTok.Tok == "(" {
-		f := FunCallerExprAST{}
+		f := FunCallerExprAST{Offset: offset}
 		if _, ok := defFunc[name]; !ok {
-			a.Err = errors.New(
-				fmt.Sprintf("function `%s` is undefined\n%s",
-					name,
-					ErrPos(a.source, a.currTok.Offset)))
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"function `%s` is undefined",
+				name)
 			return f
 		}
 		a.getNextToken()
@@ -300,12 +702,11 @@ func (a *AST) parseFunCallerOrConst() ExprAST {
 		}
 		def := defFunc[name]
 		if def.argc >= 0 && len(exprs) != def.argc {
-			a.Err = errors.New(
-				fmt.Sprintf("wrong way calling function `%s`, parameters want %d but get %d\n%s",
-					name,
-					def.argc,
-					len(exprs),
-					ErrPos(a.source, a.currTok.Offset)))
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"wrong way calling function `%s`, parameters want %d but get %d",
+				name,
+				def.argc,
+				len(exprs))
 		}
 		a.getNextToken()
 		f.Name = name
@@ -316,20 +717,27 @@ func (a *AST) parseFunCallerOrConst() ExprAST {
 	if v, ok := defConst[name]; ok {
 		return NumberExprAST{
 			Val: v,
-			Str: strconv.FormatFloat(v, 'f', 0, 64),
+			Str: strconv.FormatFloat(v, 'g', -1, 64),
 		}
-	} else {
-		// a.Err = errors.New(
-		// 	fmt.Sprintf("const `%s` is undefined\n%s",
-		// 		name,
-		// 		ErrPos(a.source, a.currTok.Offset)))
-		// return NumberExprAST{}
-
+	}
+	switch name {
+	case "true", "false":
+		return BoolExprAST{
+			Val: name == "true",
+		}
+	case "null":
+		return NullExprAST{}
+	}
+	if IdentifierMode == BareWordMode {
 		return StrExprAST{
 			Str: name,
 		}
-
 	}
+	a.Err = syntaxErrorf(a.source, offset,
+		"identifier `%s` is undefined, quote it to use it as a string or write a path such as `$.%s`",
+		name,
+		name)
+	return StrExprAST{}
 }
 
 // Get a node and return ExprAST
@@ -340,13 +748,35 @@ func (a *AST) parsePrimary() ExprAST {
 		return a.parseFunCallerOrConst()
 	case Literal:
 		return a.parseNumber()
+	case STRING:
+		s := StrExprAST{
+			Str: a.currTok.Tok,
+		}
+		a.getNextToken()
+		return s
+	case VARIABLE:
+		v := VariableExprAST{
+			Name:   a.currTok.Path[0].(string),
+			Path:   a.currTok.Path[1:],
+			Offset: a.currTok.Offset,
+		}
+		a.getNextToken()
+		return v
+	case PATH:
+		// `$` alone selects the whole source: an S with an empty path, which NewS refuses to build
+		s := SelectorExprAST{
+			Name:     "S",
+			Selector: &S{Path: a.currTok.Path},
+			Offset:   a.currTok.Offset,
+		}
+		a.getNextToken()
+		return s
 	case Operator:
 		if a.currTok.Tok == "(" {
 			t := a.getNextToken()
 			if t == nil {
-				a.Err = errors.New(
-					fmt.Sprintf("want '(' or '0-9' but get EOF\n%s",
-						ErrPos(a.source, a.currTok.Offset)))
+				a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+					"want '(' or '0-9' but get EOF")
 				return nil
 			}
 			e := a.ParseExpression()
@@ -354,35 +784,50 @@ func (a *AST) parsePrimary() ExprAST {
 				return nil
 			}
 			if a.currTok.Tok != ")" {
-				a.Err = errors.New(
-					fmt.Sprintf("want ')' but get %s\n%s",
-						a.currTok.Tok,
-						ErrPos(a.source, a.currTok.Offset)))
+				a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+					"want ')' but get %s",
+					a.currTok.Tok)
 				return nil
 			}
 			a.getNextToken()
 			return e
 		} else if a.currTok.Tok == "-" {
+			offset := a.currTok.Offset
 			if a.getNextToken() == nil {
-				a.Err = errors.New(
-					fmt.Sprintf("want '0-9' but get '-'\n%s",
-						ErrPos(a.source, a.currTok.Offset)))
+				a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+					"want '0-9' but get '-'")
 				return nil
 			}
 			bin := BinaryExprAST{
-				Op:  "-",
-				Lhs: NumberExprAST{},
-				Rhs: a.parsePrimary(),
+				Op:     "-",
+				Lhs:    NumberExprAST{Str: "0"},
+				Rhs:    a.parsePrimary(),
+				Offset: offset,
 			}
 			return bin
+		} else if a.currTok.Tok == "!" {
+			offset := a.currTok.Offset
+			if a.getNextToken() == nil {
+				a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+					"want an operand but get '!'")
+				return nil
+			}
+			operand := a.parsePrimary()
+			if operand == nil {
+				return nil
+			}
+			return UnaryExprAST{
+				Op:      "!",
+				Operand: operand,
+				Offset:  offset,
+			}
 		} else {
 			return a.parseNumber()
 		}
 	case COMMA:
-		a.Err = errors.New(
-			fmt.Sprintf("want '(' or '0-9' but get %s\n%s",
-				a.currTok.Tok,
-				ErrPos(a.source, a.currTok.Offset)))
+		a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+			"want '(' or '0-9' but get %s",
+			a.currTok.Tok)
 		return nil
 	case FUCTION:
 		return a.parseFunction()
@@ -400,12 +845,19 @@ func (a *AST) parseBinOpRHS(execPrec int, lhs ExprAST) ExprAST {
 			return lhs
 		}
 		binOp := a.currTok.Tok
+		offset := a.currTok.Offset
 		if a.getNextToken() == nil {
-			a.Err = errors.New(
-				fmt.Sprintf("want '(' or '0-9' but get EOF\n%s",
-					ErrPos(a.source, a.currTok.Offset)))
+			a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+				"want '(' or '0-9' but get EOF")
 			return nil
 		}
+		if binOp == "?" {
+			lhs = a.parseTernary(tokPrec, lhs, offset)
+			if lhs == nil {
+				return nil
+			}
+			continue
+		}
 		rhs := a.parsePrimary()
 		if rhs == nil {
 			return nil
@@ -418,9 +870,44 @@ func (a *AST) parseBinOpRHS(execPrec int, lhs ExprAST) ExprAST {
 			}
 		}
 		lhs = BinaryExprAST{
-			Op:  binOp,
-			Lhs: lhs,
-			Rhs: rhs,
+			Op:     binOp,
+			Lhs:    lhs,
+			Rhs:    rhs,
+			Offset: offset,
 		}
 	}
 }
+
+// Parse the branches of `cond ? a : b`, the current token is the first one after `?`.
+// The else branch binds at the same priority so that nested ternaries are right associative
+func (a *AST) parseTernary(tokPrec int, cond ExprAST, offset int) ExprAST {
+	then := a.ParseExpression()
+	if then == nil || a.Err != nil {
+		return nil
+	}
+	if a.currTok.Tok != ":" || a.currTok.Type != Operator {
+		a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+			"want ':' but get %s",
+			a.currTok.Tok)
+		return nil
+	}
+	if a.getNextToken() == nil {
+		a.Err = syntaxErrorf(a.source, a.currTok.Offset,
+			"want an expression after ':' but get EOF")
+		return nil
+	}
+	els := a.parsePrimary()
+	if els == nil {
+		return nil
+	}
+	els = a.parseBinOpRHS(tokPrec, els)
+	if els == nil {
+		return nil
+	}
+	return TernaryExprAST{
+		Cond:   cond,
+		Then:   then,
+		Else:   els,
+		Offset: offset,
+	}
+}
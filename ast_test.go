@@ -1,7 +1,10 @@
 package whiteboard
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -137,3 +140,382 @@ func Test_Fuction_AST_IF(t *testing.T) {
 		t.Errorf("Expected Fuction, but got %f", r)
 	}
 }
+
+func Test_Fuction_AST_Logical(t *testing.T) {
+	source := map[string]interface{}{
+		"age":    20,
+		"name":   "Li",
+		"active": true,
+	}
+	testCases := []struct {
+		exp  string
+		want interface{}
+	}{
+		{"S(\"age\") >= 18", true},
+		{"S(\"age\") < 18", false},
+		{"S(\"age\") == 20 && S(\"active\") == S(\"active\")", true},
+		{"S(\"age\") != 20 || S(\"name\") == K(\"Li\")", true},
+		{"!(S(\"age\") > 18)", false},
+		{"1 + 2 * 3 == 7 && 2 ^ 2 > 3", true},
+		{"S(\"name\") > K(\"Ann\")", true},
+		// the right operand is not evaluated when the left one decides the result
+		{"S(\"active\") || S(\"missing\")", true},
+		{"!S(\"active\") && S(\"missing\")", false},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		ast := NewAST(toks, tc.exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", tc.exp, ast.Err)
+		}
+		r, err := ExprASTResultWithContext(ar, source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+		}
+		if r != tc.want {
+			t.Errorf("%s: expected %v, but got %v", tc.exp, tc.want, r)
+		}
+	}
+}
+
+func Test_Fuction_AST_Logical_Parse_Error(t *testing.T) {
+	for _, exp := range []string{"1 = 2", "1 & 2", "1 | 2"} {
+		if _, err := Parse(exp); err == nil {
+			t.Errorf("%s: expected a lexical error", exp)
+		}
+	}
+}
+
+func Test_Fuction_AST_Conditional(t *testing.T) {
+	source := map[string]interface{}{
+		"age":      20,
+		"nickname": nil,
+		"list":     []interface{}{"a"},
+	}
+	testCases := []struct {
+		exp  string
+		want interface{}
+	}{
+		{"S(\"age\") >= 18 ? K(\"adult\") : K(\"minor\")", "adult"},
+		{"S(\"age\") < 18 ? K(\"minor\") : S(\"age\") < 60 ? K(\"adult\") : K(\"senior\")", "adult"},
+		{"(S(\"age\") > 30 ? 1 : 2) + 1", int64(3)},
+		{"S(\"name\") ?? K(\"anonymous\")", "anonymous"},
+		{"S(\"nickname\") ?? K(\"none\")", "none"},
+		{"S(\"list\", 3) ?? S(\"list\", 0)", "a"},
+		{"S(\"age\") ?? K(0)", 20},
+		{"S(\"a\") ?? S(\"b\") ?? K(\"c\")", "c"},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		ast := NewAST(toks, tc.exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", tc.exp, ast.Err)
+		}
+		r, err := ExprASTResultWithContext(ar, source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+		}
+		if r != tc.want {
+			t.Errorf("%s: expected %v, but got %v", tc.exp, tc.want, r)
+		}
+	}
+
+	exp := "S(\"age\") > 18 ? K(1)"
+	toks, _ := Parse(exp)
+	ast := NewAST(toks, exp)
+	ast.ParseExpression()
+	if ast.Err == nil {
+		t.Errorf("%s: expected a syntax error for the missing ':'", exp)
+	}
+}
+
+func Test_Fuction_AST_Literal(t *testing.T) {
+	source := map[string]interface{}{
+		"active":   true,
+		"nickname": nil,
+	}
+	testCases := []struct {
+		exp  string
+		want interface{}
+	}{
+		{"true", true},
+		{"null", nil},
+		{"S(\"active\") == true", true},
+		{"S(\"active\") == false && (1 > 2)", false},
+		{"S(\"nickname\") == null", true},
+		{"K(true)", true},
+		{"IF(false, K(1), K(2))", int64(2)},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		ast := NewAST(toks, tc.exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", tc.exp, ast.Err)
+		}
+		r, err := ExprASTResultWithContext(ar, source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+		}
+		if r != tc.want {
+			t.Errorf("%s: expected %v, but got %v", tc.exp, tc.want, r)
+		}
+	}
+}
+
+func Test_Fuction_AST_Bare_Word(t *testing.T) {
+	exp := "K(\"a\") + abc"
+	toks, _ := Parse(exp)
+	ast := NewAST(toks, exp)
+	ast.ParseExpression()
+	if ast.Err == nil {
+		t.Errorf("%s: expected an undefined identifier error", exp)
+	}
+
+	IdentifierMode = BareWordMode
+	defer func() { IdentifierMode = StrictWordMode }()
+
+	ast = NewAST(toks, exp)
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		t.Fatalf("%s: unexpected syntax error: %v", exp, ast.Err)
+	}
+	r, err := ExprASTResultWithContext(ar, nil)
+	if err != nil || r != "aabc" {
+		t.Errorf("%s: expected aabc, but got %v (%v)", exp, r, err)
+	}
+}
+
+func Test_Fuction_AST_String_Escape(t *testing.T) {
+	testCases := []struct {
+		exp  string
+		want interface{}
+	}{
+		{`"say \"hi\""`, `say "hi"`},
+		{`'say "hi"'`, `say "hi"`},
+		{`'it\'s' + "\tok\n"`, "it's\tok\n"},
+		{`"back\\slash"`, `back\slash`},
+		{`"café \x41"`, "café A"},
+		{`K('a,b') + K("(c)")`, "a,b(c)"},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		ast := NewAST(toks, tc.exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", tc.exp, ast.Err)
+		}
+		r, err := ExprASTResultWithContext(ar, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+		}
+		if r != tc.want {
+			t.Errorf("%s: expected %q, but got %q", tc.exp, tc.want, r)
+		}
+	}
+
+	for _, exp := range []string{`"abc`, `'abc"`, `S("a\q")`, `"abc\`} {
+		if _, err := Parse(exp); err == nil {
+			t.Errorf("%s: expected a lexical error", exp)
+		}
+	}
+}
+
+func Test_Fuction_AST_Path(t *testing.T) {
+	source := map[string]interface{}{
+		"a": []interface{}{
+			map[string]interface{}{"b": 42, "key with space": "x"},
+		},
+		"init":    map[string]interface{}{"user_id": "123"},
+		"country": "China",
+	}
+	testCases := []struct {
+		exp  string
+		path []interface{}
+		want interface{}
+	}{
+		{"$.a[0].b", []interface{}{"a", 0, "b"}, 42},
+		{"a.0.b", []interface{}{"a", 0, "b"}, 42},
+		{"a[0][\"key with space\"]", []interface{}{"a", 0, "key with space"}, "x"},
+		{"a[ 0 ]['key with space']", []interface{}{"a", 0, "key with space"}, "x"},
+		{"init.user_id", []interface{}{"init", "user_id"}, "123"},
+		{"$.country", []interface{}{"country"}, "China"},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		ast := NewAST(toks, tc.exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", tc.exp, ast.Err)
+		}
+		expect, _ := NewS(tc.path...)
+		if sea, ok := ar.(SelectorExprAST); !ok || !reflect.DeepEqual(sea.Selector, expect) {
+			t.Errorf("%s: expected selector %v, but got %v", tc.exp, expect, ar)
+		}
+		r, err := ExprASTResultWithContext(ar, source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+		}
+		if r != tc.want {
+			t.Errorf("%s: expected %v, but got %v", tc.exp, tc.want, r)
+		}
+	}
+
+	exp := "$.country == \"China\" ? init.user_id : $"
+	toks, _ := Parse(exp)
+	ast := NewAST(toks, exp)
+	ar := ast.ParseExpression()
+	if ast.Err != nil {
+		t.Fatalf("%s: unexpected syntax error: %v", exp, ast.Err)
+	}
+	if r, _ := ExprASTResultWithContext(ar, source); r != "123" {
+		t.Errorf("%s: expected 123, but got %v", exp, r)
+	}
+
+	// `$` alone is the whole source
+	toks, _ = Parse("$")
+	ar = NewAST(toks, "$").ParseExpression()
+	if sea, ok := ar.(SelectorExprAST); !ok || !reflect.DeepEqual(sea.Selector, &S{Path: []interface{}{}}) {
+		t.Errorf("$: expected an S with an empty path, but got %v", ar)
+	}
+	if r, err := ExprASTResultWithContext(ar, source); err != nil || !reflect.DeepEqual(r, source) {
+		t.Errorf("$: expected the source, but got %v (%v)", r, err)
+	}
+
+	// a bare word is not a path
+	toks, _ = Parse("country")
+	ast = NewAST(toks, "country")
+	ast.ParseExpression()
+	if !errors.Is(ast.Err, ErrSyntax) {
+		t.Errorf("country: expected an undefined identifier error, but got %v", ast.Err)
+	}
+
+	for _, exp := range []string{"a.", "a[0", "a[b]", "$.[0]"} {
+		if _, err := Parse(exp); err == nil {
+			t.Errorf("%s: expected a lexical error", exp)
+		}
+	}
+}
+
+func Test_Fuction_AST_Path_Wildcard(t *testing.T) {
+	testCases := []struct {
+		exp  string
+		path []interface{}
+	}{
+		{"pets[*].name", []interface{}{"pets", Wildcard, "name"}},
+		{"pets.*.name", []interface{}{"pets", Wildcard, "name"}},
+		{"$..name", []interface{}{RecursiveDescent, "name"}},
+		{"$..[0]", []interface{}{RecursiveDescent, 0}},
+		{"a..*", []interface{}{"a", RecursiveDescent, Wildcard}},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		if len(toks) != 1 || !reflect.DeepEqual(toks[0].Path, tc.path) {
+			t.Errorf("%s: expected path %v, but got %v", tc.exp, tc.path, toks[0].Path)
+		}
+	}
+
+	if _, err := Parse("$.."); err == nil {
+		t.Errorf("$..: expected a lexical error")
+	}
+}
+
+func Test_Fuction_AST_Variable(t *testing.T) {
+	exp := "$root.a[0] + 1"
+	toks, err := Parse(exp)
+	if err != nil {
+		t.Fatalf("%s: unexpected lexical error: %v", exp, err)
+	}
+	if toks[0].Type != VARIABLE || !reflect.DeepEqual(toks[0].Path, []interface{}{"$root", "a", 0}) {
+		t.Errorf("%s: expected a variable token, but got %v", exp, toks[0])
+	}
+	ar := NewAST(toks, exp).ParseExpression()
+	lhs := ar.(BinaryExprAST).Lhs
+	if !reflect.DeepEqual(lhs, VariableExprAST{Name: "$root", Path: []interface{}{"a", 0}, Offset: 0}) {
+		t.Errorf("%s: expected a variable node, but got %v", exp, lhs)
+	}
+
+	source := map[string]interface{}{"a": []interface{}{41}}
+	if r, err := ExprASTResultWithContext(ar, source); err != nil || r != int64(42) {
+		t.Errorf("%s: expected 42, but got %v (%v)", exp, r, err)
+	}
+	toks, _ = Parse("$item")
+	ar = NewAST(toks, "$item").ParseExpression()
+	if _, err := ExprASTResultWithContext(ar, source); !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "variable $item is not bound") {
+		t.Errorf("$item: expected an unbound variable error, but got %v", err)
+	}
+}
+
+func Test_Fuction_AST_Eval_Error(t *testing.T) {
+	source := map[string]interface{}{"age": "old", "ok": 1}
+	testCases := []struct {
+		exp    string
+		offset int
+	}{
+		{"1 + S(\"age\") * 2", 13},
+		{"S(\"missing\") + 1", 0},
+		{"abs(S(\"age\"))", 0},
+		{"!S(\"ok\")", 0},
+		{"S(\"ok\") ? 1 : 2", 8},
+		{"true && S(\"ok\")", 5},
+		{"IF(S(\"ok\") + \"x\", 1, 2)", 11},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		ast := NewAST(toks, tc.exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", tc.exp, ast.Err)
+		}
+		_, err = ExprASTResultWithContext(ar, source)
+		var evalErr *EvalError
+		if !errors.As(err, &evalErr) {
+			t.Errorf("%s: expected an *EvalError, but got %v", tc.exp, err)
+			continue
+		}
+		if evalErr.Offset != tc.offset {
+			t.Errorf("%s: expected the error at %d, but got %d", tc.exp, tc.offset, evalErr.Offset)
+		}
+	}
+
+	if _, err := ParseAndExec("2 * (1 / 0)"); err == nil || !strings.Contains(err.Error(), "^") {
+		t.Errorf("expected a located division by zero, but got %v", err)
+	}
+
+	RegFunction("legacy_panic", 0, func(expr ...ExprAST) float64 {
+		panic(errors.New("boom"))
+	})
+	if _, err := ParseAndExec("legacy_panic() + 1"); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the panic of a registered function as an error, but got %v", err)
+	}
+}
@@ -3,20 +3,29 @@ package whiteboard
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
-
-	"github.com/sirupsen/logrus"
+	"strings"
 )
 
 type Transport struct {
 	value   interface{}
 	context map[interface{}]interface{}
+
+	options bendOptions
+	// failures collected in CollectErrors mode
+	errs *BendErrors
+	// variables of the expressions, `$root` and the ones bound by ForAll
+	scope *scope
+	// node the entries are recorded under by Explain
+	trace *Trace
 }
 
 func NewTransport(value interface{}, context map[interface{}]interface{}) *Transport {
 	return &Transport{
 		value:   value,
 		context: context,
+		errs:    &BendErrors{},
 	}
 }
 
@@ -29,18 +38,64 @@ func (t *Transport) FromSource(src interface{}) *Transport {
 	}
 }
 
-var (
-	logger *logrus.Entry
-)
-
+// BendingException reports a failing mapping, Err is the underlying cause
+// so that errors.Is and errors.As see through it
 type BendingException struct {
 	Message string
+	Err     error
 }
 
 func (e *BendingException) Error() string {
 	return e.Message
 }
 
+func (e *BendingException) Unwrap() error {
+	return e.Err
+}
+
+// BendError reports the mapping entry that failed to compile or bend.
+// Key is the JSON pointer of the entry in the mapping such as /spec/stars/2/param/name,
+// Expression its text and Path the selector path that could not be followed in the source, if any
+type BendError struct {
+	Key        string
+	Expression string
+	Path       []interface{}
+	Err        error
+}
+
+// newBendError locates err at key, a *BendError of a nested mapping such as the item mapping
+// of a Map is returned as is with key prepended to its own, so that Key is the complete location
+func newBendError(key, expression string, err error) *BendError {
+	var nested *BendError
+	if errors.As(err, &nested) {
+		located := *nested
+		located.Key = key + nested.Key
+		return &located
+	}
+	e := &BendError{Key: key, Expression: expression, Err: err}
+	var pathErr *PathError
+	if errors.As(err, &pathErr) {
+		e.Path = pathErr.Path
+	}
+	return e
+}
+
+func (e *BendError) Error() string {
+	key := e.Key
+	if key == "" {
+		key = "/"
+	}
+	return fmt.Sprintf("Error for key %s: %v", key, e.Err)
+}
+
+func (e *BendError) Unwrap() error {
+	return e.Err
+}
+
+// Bend evaluates mapping against source. args may hold the context, a map[interface{}]interface{}
+// consulted when an expression finds nothing in source, and any number of Option.
+// A Selector value in mapping, e.g. one built with NewS, is executed against source like
+// an expression rather than copied into the result
 func Bend(mapping interface{}, source interface{}, args ...interface{}) (interface{}, error) {
 	// check whether mapping and source are empty
 	if mapping == nil || source == nil {
@@ -48,98 +103,571 @@ func Bend(mapping interface{}, source interface{}, args ...interface{}) (interfa
 	}
 
 	context := make(map[interface{}]interface{})
-	// logger.Info("Bending source with mapping", source, mapping)
-	if len(args) > 0 {
-		context, _ = args[0].(map[interface{}]interface{})
+	var opts []Option
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case map[interface{}]interface{}:
+			context = a
+		case Option:
+			opts = append(opts, a)
+		}
+	}
+	bender, err := Compile(mapping)
+	if err != nil {
+		return nil, err
+	}
+	return bender.Bend(source, context, opts...)
+}
+
+// Option configures a single call of Bend
+type Option func(*bendOptions)
+
+type bendOptions struct {
+	collectErrors bool
+	placeholder   interface{}
+}
+
+// CollectErrors makes Bend go on through the whole mapping instead of stopping at the first failure.
+// Every failed key is set to placeholder, or to the zero value when placeholder does not fit,
+// and the failures are returned together with the result in a *BendErrors
+func CollectErrors(placeholder interface{}) Option {
+	return func(o *bendOptions) {
+		o.collectErrors = true
+		o.placeholder = placeholder
+	}
+}
+
+// BendErrors lists every failing entry of a mapping bent with CollectErrors
+type BendErrors struct {
+	Errors []*BendError
+}
+
+func (e *BendErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d key(s) failed to bend:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+func (e *BendErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// collect records the failure of the entry at path in CollectErrors mode
+// and reports whether bending goes on with the placeholder
+func (t *Transport) collect(err error, path string) bool {
+	if !t.options.collectErrors {
+		return false
+	}
+	var bendErr *BendError
+	if !errors.As(err, &bendErr) {
+		bendErr = newBendError(path, "", err)
+	}
+	t.errs.Errors = append(t.errs.Errors, bendErr)
+	return true
+}
+
+// env is the env the expressions of the mapping are evaluated within
+func (t *Transport) env() env {
+	return env{scope: t.scope, transport: t, trace: t.trace}
+}
+
+// rebase prefixes the keys of the failures collected after the first n with key,
+// the JSON pointer of the part of the mapping they were collected in
+func (t *Transport) rebase(n int, key string) {
+	for _, err := range t.errs.Errors[n:] {
+		err.Key = key + err.Key
+	}
+}
+
+// Bender is a mapping compiled once by Compile and bent many times.
+// It only holds immutable parsed expressions, so it is safe to share across goroutines
+type Bender struct {
+	mapping interface{}
+}
+
+// Compile lexes and parses every expression of mapping up front,
+// all syntax errors are reported together in a *CompileError
+func Compile(mapping interface{}) (*Bender, error) {
+	if mapping == nil {
+		return nil, errors.New("mapping is empty")
+	}
+	errs := &CompileError{}
+	compiled := compileMapping(mapping, "", errs)
+	if len(errs.Errors) > 0 {
+		return nil, errs
+	}
+	return &Bender{mapping: compiled}, nil
+}
+
+// Bend evaluates the compiled mapping against source, context is consulted
+// when an expression finds nothing in source
+func (b *Bender) Bend(source interface{}, context map[interface{}]interface{}, opts ...Option) (result interface{}, err error) {
+	// a bad record must fail on its own rather than crash a whole batch
+	defer recoverError(&err)
+	if source == nil {
+		return nil, errors.New("mapping or source is empty")
+	}
+	if context == nil {
+		context = make(map[interface{}]interface{})
+	}
+	transport := newRootTransport(source, context)
+	for _, opt := range opts {
+		opt(&transport.options)
+	}
+
+	result, err = _bend(b.mapping, transport, "")
+	if errors.Is(err, errOmitted) {
+		return nil, nil
 	}
+	if err != nil {
+		if !transport.collect(err, "") {
+			return nil, err
+		}
+		result = transport.options.placeholder
+	}
+	if len(transport.errs.Errors) > 0 {
+		return result, transport.errs
+	}
+	return result, nil
+}
+
+// newRootTransport is the transport a whole mapping is bent with, `$root` is source
+func newRootTransport(source interface{}, context map[interface{}]interface{}) *Transport {
 	transport := NewTransport(source, context)
-	return _bend(mapping, transport)
+	transport.scope = rootEnv(source).scope
+	return transport
 }
 
-func _bend(mapping interface{}, transport *Transport) (interface{}, error) {
+// CompileError lists every expression of a mapping that failed to parse
+type CompileError struct {
+	Errors []error
+}
 
-	t := reflect.TypeOf(mapping)
-	fmt.Println(t.Kind())
+func (e *CompileError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d expression(s) failed to compile:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+func (e *CompileError) Unwrap() []error {
+	return e.Errors
+}
+
+// compiledMap keeps the type of the mapping so that the bent result has the same type
+type compiledMap struct {
+	typ    reflect.Type
+	keys   []reflect.Value
+	values []interface{}
+}
+
+type compiledList struct {
+	values []interface{}
+}
+
+type compiledExpression struct {
+	exp string
+	ast ExprAST
+	// JSON pointer of the expression in the mapping
+	key string
+}
+
+func compileMapping(mapping interface{}, path string, errs *CompileError) interface{} {
+	if mapping == nil {
+		return nil
+	}
 	mValue := reflect.ValueOf(mapping)
 
-	switch t.Kind() {
+	switch mValue.Kind() {
 	case reflect.Array, reflect.Slice:
-		result := make([]interface{}, mValue.Len())
+		list := &compiledList{values: make([]interface{}, mValue.Len())}
 		for i := 0; i < mValue.Len(); i++ {
-			item := mValue.Index(i)
-			val, err := _bend(item, transport)
-			if err != nil {
-				return nil, err
-			}
-			result[i] = val
+			list.values[i] = compileMapping(mValue.Index(i).Interface(), jsonPointer(path, i), errs)
 		}
-		return result, nil
+		return list
 	case reflect.Map:
-		// result := make(map[string]interface{})
-		result := reflect.New(t).Elem()
-		result.Set(reflect.MakeMap(t))
-		keys := mValue.MapKeys()
-		for _, key := range keys {
-
-			val, err := _bend(mValue.MapIndex(key).Interface(), transport)
-			if err != nil {
-				return nil, &BendingException{
-					Message: fmt.Sprintf("Error for key %v: %v", key, err.Error()),
-				}
-			}
-			// result[key.Interface().(string)] = val
-			valValue := reflect.ValueOf(val)
-			result.SetMapIndex(key, valValue)
+		m := &compiledMap{typ: mValue.Type()}
+		for _, key := range sortedMapKeys(mValue) {
+			m.keys = append(m.keys, key)
+			m.values = append(m.values, compileMapping(mValue.MapIndex(key).Interface(), jsonPointer(path, key.Interface()), errs))
 		}
-		return result.Interface(), nil
+		return m
 	case reflect.String:
-		val, err := bendExpression(mValue.Interface(), transport)
-		return val, err
-
+		expression, err := compileExpression(mValue.String(), path)
+		if err != nil {
+			errs.Errors = append(errs.Errors, newBendError(path, mValue.String(), err))
+		}
+		return expression
 	default:
-		return mapping, nil
+		return mapping
 	}
 }
 
-func bendExpression(mapping interface{}, transport *Transport) (interface{}, error) {
-	exp := mapping.(string)
+func compileExpression(exp string, key string) (*compiledExpression, error) {
 	toks, err := Parse(exp)
 
 	if err != nil {
 		return nil, &BendingException{
-			Message: fmt.Sprintf("Error for lexical analysis: mapping: %v, error: %v", mapping, err.Error()),
+			Message: fmt.Sprintf("Error for lexical analysis: mapping: %v, error: %v", exp, err.Error()),
+			Err:     err,
 		}
 	}
 	// []token -> AST Tree
 	ast := NewAST(toks, exp)
 	if ast.Err != nil {
-		fmt.Println("ERROR: " + ast.Err.Error())
+		logger.Debugf("cannot build the AST of %s: %v", exp, ast.Err)
 		return nil, &BendingException{
-			Message: fmt.Sprintf("Error for NewAst: mapping: %v, error: %v", mapping, ast.Err.Error()),
+			Message: fmt.Sprintf("Error for NewAst: mapping: %v, error: %v", exp, ast.Err.Error()),
+			Err:     ast.Err,
 		}
 	}
 
 	// AST builder
 	ar := ast.ParseExpression()
 	if ast.Err != nil {
-		fmt.Println("ERROR: " + ast.Err.Error())
+		logger.Debugf("cannot parse %s: %v", exp, ast.Err)
 		return nil, &BendingException{
-			Message: fmt.Sprintf("Error for AST builder: mapping: %v, error: %v", mapping, ast.Err.Error()),
+			Message: fmt.Sprintf("Error for AST builder: mapping: %v, error: %v", exp, ast.Err.Error()),
+			Err:     ast.Err,
+		}
+	}
+
+	logger.Debugf("compiled %s: %s", exp, ar.toStr())
+	return &compiledExpression{exp: exp, ast: ar, key: key}, nil
+}
+
+// BendInto bends source with mapping and stores the result in dst, a non-nil pointer to a struct,
+// a map or any other value the result converts to. Map keys are matched with struct fields by their
+// `json`/`yaml` tag or Go name and numbers are converted when no precision is lost, e.g. int64 -> int.
+// Every field that cannot be assigned is reported at once in an *AssignError, joined with
+// the *BendErrors of CollectErrors when bending failed as well
+func BendInto(mapping interface{}, source interface{}, dst interface{}, args ...interface{}) error {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("BendInto wants a non-nil pointer destination but get %T", dst)
+	}
+	result, err := Bend(mapping, source, args...)
+	var bendErrs *BendErrors
+	if err != nil && !errors.As(err, &bendErrs) {
+		return err
+	}
+
+	// with CollectErrors the partial result is still assigned
+	assignErr := &AssignError{}
+	assignValue(rv.Elem(), result, "", assignErr)
+	if len(assignErr.Fields) > 0 {
+		if err != nil {
+			return errors.Join(err, assignErr)
+		}
+		return assignErr
+	}
+	return err
+}
+
+// FieldAssignError describes one destination field BendInto could not assign,
+// Field is a JSON pointer such as /pets/1/age
+type FieldAssignError struct {
+	Field  string
+	Value  interface{}
+	Reason string
+}
+
+// AssignError lists every field BendInto could not assign
+type AssignError struct {
+	Fields []FieldAssignError
+}
+
+func (e *AssignError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "cannot assign %d field(s)", len(e.Fields))
+	for _, f := range e.Fields {
+		field := f.Field
+		if field == "" {
+			field = "/"
+		}
+		fmt.Fprintf(&sb, "\n\t%s: %s", field, f.Reason)
+	}
+	return sb.String()
+}
+
+func (e *AssignError) add(field string, value interface{}, reason string) {
+	e.Fields = append(e.Fields, FieldAssignError{Field: field, Value: value, Reason: reason})
+}
+
+func assignValue(dst reflect.Value, value interface{}, path string, errs *AssignError) {
+	if value == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return
+	}
+	src := reflect.ValueOf(value)
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return
+	}
+	src = indirect(src)
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		assignValue(dst.Elem(), value, path, errs)
+		return
+	case reflect.Struct:
+		if src.Kind() != reflect.Map {
+			break
+		}
+		for _, key := range sortedMapKeys(src) {
+			name := fmt.Sprint(key.Interface())
+			fieldPath := jsonPointer(path, name)
+			index, ok := findStructField(dst.Type(), name)
+			if !ok {
+				errs.add(fieldPath, src.MapIndex(key).Interface(), fmt.Sprintf("no such field in %s", dst.Type()))
+				continue
+			}
+			field, ok := allocFieldByIndex(dst, index)
+			if !ok {
+				errs.add(fieldPath, src.MapIndex(key).Interface(), fmt.Sprintf("field %s of %s is unexported", name, dst.Type()))
+				continue
+			}
+			assignValue(field, src.MapIndex(key).Interface(), fieldPath, errs)
+		}
+		return
+	case reflect.Map:
+		if src.Kind() != reflect.Map {
+			break
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), src.Len()))
+		}
+		for _, key := range sortedMapKeys(src) {
+			fieldPath := jsonPointer(path, key.Interface())
+			k := reflect.New(dst.Type().Key()).Elem()
+			if kv := indirect(key); kv.IsValid() && kv.Type().AssignableTo(k.Type()) {
+				k.Set(kv)
+			} else if !convertScalar(k, kv) {
+				errs.add(fieldPath, key.Interface(), fmt.Sprintf("cannot use key %T as %s", key.Interface(), k.Type()))
+				continue
+			}
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			assignValue(elem, src.MapIndex(key).Interface(), fieldPath, errs)
+			dst.SetMapIndex(k, elem)
+		}
+		return
+	case reflect.Slice, reflect.Array:
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			break
+		}
+		if dst.Kind() == reflect.Slice {
+			dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		} else if dst.Len() != src.Len() {
+			errs.add(path, value, fmt.Sprintf("cannot assign %d elements to %s", src.Len(), dst.Type()))
+			return
+		}
+		for i := 0; i < src.Len(); i++ {
+			assignValue(dst.Index(i), src.Index(i).Interface(), jsonPointer(path, i), errs)
+		}
+		return
+	case reflect.Interface:
+		// a typed nil pointer may leave nothing to assign after indirection
+		if src.IsValid() && src.Type().Implements(dst.Type()) {
+			dst.Set(src)
+			return
+		}
+	default:
+		if convertScalar(dst, src) {
+			return
+		}
+	}
+	errs.add(path, value, fmt.Sprintf("cannot assign %T to %s", value, dst.Type()))
+}
+
+// allocFieldByIndex returns the nested field, allocating nil embedded pointers on the way
+func allocFieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
 		}
+		v = v.Field(x)
 	}
+	return v, v.CanSet()
+}
 
-	fmt.Printf("ExprAST: %+v\n", ar)
+// convertScalar stores src into dst when both are numbers and the value survives the conversion,
+// or when both are strings or both are bools
+func convertScalar(dst, src reflect.Value) bool {
+	if !src.IsValid() {
+		return false
+	}
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := exactInt(src)
+		if !ok || dst.OverflowInt(i) {
+			return false
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := exactInt(src)
+		if !ok || i < 0 || dst.OverflowUint(uint64(i)) {
+			return false
+		}
+		dst.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat64(src.Interface())
+		if !ok || dst.OverflowFloat(f) {
+			return false
+		}
+		dst.SetFloat(f)
+	case reflect.String:
+		if src.Kind() != reflect.String {
+			return false
+		}
+		dst.SetString(src.String())
+	case reflect.Bool:
+		if src.Kind() != reflect.Bool {
+			return false
+		}
+		dst.SetBool(src.Bool())
+	default:
+		return false
+	}
+	return true
+}
 
+// exactInt reads an integer out of any numeric value, floats only qualify without a fraction
+func exactInt(v reflect.Value) (int64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// _bend evaluates a compiled mapping, path is the JSON pointer of mapping used to locate errors
+func _bend(mapping interface{}, transport *Transport, path string) (result interface{}, err error) {
+	if transport.trace != nil {
+		parent, t := transport.trace, &Trace{Key: path, Node: mappingNode(mapping)}
+		parent.Children = append(parent.Children, t)
+		transport.trace = t
+		defer func() {
+			transport.trace = parent
+			t.Err = err
+			if t.Node != "map" && t.Node != "list" {
+				t.Value = result
+			}
+		}()
+	}
+
+	switch m := mapping.(type) {
+	case *compiledList:
+		result := make([]interface{}, 0, len(m.values))
+		for i, item := range m.values {
+			itemPath := jsonPointer(path, i)
+			val, err := _bend(item, transport, itemPath)
+			if errors.Is(err, errOmitted) {
+				continue
+			}
+			if err != nil {
+				if !transport.collect(err, itemPath) {
+					return nil, err
+				}
+				val = transport.options.placeholder
+			}
+			result = append(result, val)
+		}
+		return result, nil
+	case *compiledMap:
+		result := reflect.MakeMapWithSize(m.typ, len(m.keys))
+		for i, key := range m.keys {
+			keyPath := jsonPointer(path, key.Interface())
+			val, err := _bend(m.values[i], transport, keyPath)
+			if errors.Is(err, errOmitted) {
+				// an IF without else branch whose condition is false
+				continue
+			}
+			if err != nil {
+				if !transport.collect(err, keyPath) {
+					return nil, err
+				}
+				val = transport.options.placeholder
+			}
+			valValue := reflect.ValueOf(val)
+			if valValue.IsValid() && !valValue.Type().AssignableTo(m.typ.Elem()) {
+				if err == nil {
+					err := errorOf(ErrTypeMismatch, "%T is not assignable to %s", val, m.typ.Elem())
+					if !transport.collect(err, keyPath) {
+						return nil, newBendError(keyPath, "", err)
+					}
+				}
+				// the placeholder does not fit the map either
+				valValue = reflect.Value{}
+			}
+			if !valValue.IsValid() {
+				valValue = reflect.Zero(m.typ.Elem())
+			}
+			result.SetMapIndex(key, valValue)
+		}
+		return result.Interface(), nil
+	case *compiledExpression:
+		return bendExpression(m, transport)
+	case Selector:
+		// a selector built in Go, e.g. the item mapping of a Map
+		n := len(transport.errs.Errors)
+		r, err := executeNode(m, transport.value, transport.env())
+		transport.rebase(n, path)
+		if err != nil && !errors.Is(err, errOmitted) {
+			return nil, newBendError(path, "", err)
+		}
+		return r, err
+
+	default:
+		return mapping, nil
+	}
+}
+
+func bendExpression(expression *compiledExpression, transport *Transport) (interface{}, error) {
 	// AST traversal -> result
-	r, err := ExprASTResultWithContext(ar, transport.value)
+	e := transport.env()
+	n := len(transport.errs.Errors)
+	r, err := evalExpr(expression.ast, transport.value, e)
 
 	if r == nil && len(transport.context) != 0 {
-		r, err = ExprASTResultWithContext(ar, transport.context)
+		// only the failures of the evaluation whose result is kept are reported
+		transport.errs.Errors = transport.errs.Errors[:n]
+		transport.trace.restart("context")
+		r, err = evalExpr(expression.ast, transport.context, e)
 	}
+	transport.rebase(n, expression.key)
 
-	fmt.Println("progressing ...\t", r)
-	fmt.Printf("%s = %v\n", exp, r)
+	logger.Debugf("%s %s = %v", expression.key, expression.exp, r)
 
-	return r, err
+	if err != nil {
+		return nil, newBendError(expression.key, expression.exp, withSource(err, expression.exp))
+	}
+	return r, nil
 
 }
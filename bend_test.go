@@ -8,6 +8,8 @@ import (
 	"os"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/ghodss/yaml"
@@ -388,3 +390,379 @@ func Test_2(t *testing.T) {
 		t.Errorf("expected output %v, but got %v", expect, data)
 	}
 }
+
+func TestBend_with_IF_inline_condition(t *testing.T) {
+	mapping := map[string]interface{}{
+		"kind": "IF(S(\"age\") >= 18 && S(\"country\") == K(\"China\"), K(\"adult\"), K(\"minor\"))",
+	}
+	source := map[string]interface{}{
+		"age":     20,
+		"country": "China",
+	}
+	output, err := Bend(mapping, source)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"kind": "adult"}
+	if !CompareMaps(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+}
+
+func TestBend_with_path(t *testing.T) {
+	mapping := map[string]interface{}{
+		"id":   "init.userId",
+		"name": "$.a[\"userName\"]",
+	}
+	output, err := Bend(mapping, ActionMaps)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"id": "123", "name": "name"}
+	if !CompareMaps(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+}
+
+func TestBend_with_JMES(t *testing.T) {
+	mapping := map[string]interface{}{
+		"names": "JMES(\"pets[?age > `2`].name | sort(@)\")",
+	}
+	source := map[string]interface{}{
+		"pets": []interface{}{
+			map[string]interface{}{"name": "dog", "age": 3},
+			map[string]interface{}{"name": "cat", "age": 2},
+			map[string]interface{}{"name": "bird", "age": 4},
+		},
+	}
+	output, err := Bend(mapping, source)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"names": []interface{}{"bird", "dog"}}
+	if !CompareMaps(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+}
+
+type bendPet struct {
+	Name string  `json:"name"`
+	Age  int     `json:"age"`
+	Size float32 `json:"size"`
+}
+
+type bendOwner struct {
+	ID     int               `json:"id"`
+	Name   string            `json:"name"`
+	Count  int               `json:"count"`
+	Pets   []bendPet         `json:"pets"`
+	Labels map[string]string `json:"labels"`
+	Best   *bendPet          `json:"best"`
+}
+
+func TestBendInto_struct(t *testing.T) {
+	mapping := map[string]interface{}{
+		"id":     "S(\"id\")",
+		"name":   "S(\"name\")",
+		"count":  "K(1) + K(2)",
+		"pets":   "S(\"pets\")",
+		"labels": map[string]interface{}{"kind": "K(\"VIP\")"},
+		"best":   "S(\"pets\", 1)",
+	}
+	source := map[string]interface{}{
+		"id":   int64(123),
+		"name": "Bob",
+		"pets": []interface{}{
+			map[string]interface{}{"name": "cat", "age": 2.0, "size": 0.5},
+			map[string]interface{}{"name": "dog", "age": 3, "size": 1.5},
+		},
+	}
+
+	var owner bendOwner
+	if err := BendInto(mapping, source, &owner); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := bendOwner{
+		ID:     123,
+		Name:   "Bob",
+		Count:  3,
+		Pets:   []bendPet{{"cat", 2, 0.5}, {"dog", 3, 1.5}},
+		Labels: map[string]string{"kind": "VIP"},
+		Best:   &bendPet{"dog", 3, 1.5},
+	}
+	if !reflect.DeepEqual(owner, expect) {
+		t.Errorf("expected output %+v, but got %+v", expect, owner)
+	}
+}
+
+func TestBendInto_errors(t *testing.T) {
+	mapping := map[string]interface{}{
+		"id":    "S(\"name\")",
+		"count": "K(2.5)",
+		"pets":  "S(\"pets\")",
+		"owner": "S(\"name\")",
+	}
+	source := map[string]interface{}{
+		"name": "Bob",
+		"pets": []interface{}{
+			map[string]interface{}{"name": "cat", "age": "two"},
+		},
+	}
+
+	var owner bendOwner
+	err := BendInto(mapping, source, &owner)
+	assignErr, ok := err.(*AssignError)
+	if !ok {
+		t.Fatalf("expected an *AssignError, but got %v", err)
+	}
+	fields := []string{}
+	for _, f := range assignErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	sort.Strings(fields)
+	expect := []string{"/count", "/id", "/owner", "/pets/0/age"}
+	if !reflect.DeepEqual(fields, expect) {
+		t.Errorf("expected failing fields %v, but got %v\n%v", expect, fields, err)
+	}
+
+	var m map[string]int
+	if err := BendInto(map[string]interface{}{"a": "K(1)"}, source, &m); err != nil || m["a"] != 1 {
+		t.Errorf("expected map[a:1], but got %v (%v)", m, err)
+	}
+	var generic map[interface{}]interface{}
+	if err := BendInto(map[string]interface{}{"a": "K(1)"}, source, &generic); err != nil || generic["a"] != int64(1) {
+		t.Errorf("expected map[a:1], but got %v (%v)", generic, err)
+	}
+
+	// the failure behind a placeholder is reported along with the field it cannot be assigned to
+	var counts map[string]int
+	err = BendInto(map[string]interface{}{"a": "S(\"zz\")"}, source, &counts, CollectErrors("PH"))
+	var bendErrs *BendErrors
+	if !errors.As(err, &bendErrs) || !errors.As(err, &assignErr) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected both the bend and the assign errors, but got %v", err)
+	}
+	if err := BendInto(mapping, source, owner); err == nil {
+		t.Errorf("expected an error for a non-pointer destination")
+	}
+
+	// typed nil pointers that do not implement the destination interface
+	var nilOwner *bendOwner
+	for _, v := range []interface{}{nilOwner, &nilOwner} {
+		var dst struct {
+			Owner fmt.Stringer `json:"owner"`
+		}
+		err := BendInto(map[string]interface{}{"owner": NewF(func(interface{}, ...interface{}) interface{} { return v })}, source, &dst)
+		if !errors.As(err, &assignErr) || len(assignErr.Fields) != 1 || assignErr.Fields[0].Field != "/owner" {
+			t.Errorf("%T: expected an AssignError for /owner, but got %v", v, err)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	mapping := map[string]interface{}{
+		"id":   "S(\"id\")",
+		"rank": 42,
+		"pets": []interface{}{"pets[0].name", "pets[1].name"},
+		"old":  "F(\"func(v interface{}, args ...interface{}) interface{} { return len(v.(map[string]interface{})) }\")",
+	}
+	bender, err := Compile(mapping)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			source := map[string]interface{}{
+				"id": id,
+				"pets": []interface{}{
+					map[string]interface{}{"name": "cat"},
+					map[string]interface{}{"name": "dog"},
+				},
+			}
+			output, err := bender.Bend(source, nil)
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			expect := map[string]interface{}{"id": id, "rank": 42, "pets": []interface{}{"cat", "dog"}, "old": 2}
+			if !CompareMaps(output, expect) {
+				t.Errorf("expected output %v, but got %v", expect, output)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCompile_all_errors(t *testing.T) {
+	mapping := map[string]interface{}{
+		"a":    "S(\"a\"",
+		"b":    "S(\"b\")",
+		"list": []interface{}{"1 +", "K(\"ok\")", "\"open"},
+	}
+	_, err := Compile(mapping)
+	compileErr, ok := err.(*CompileError)
+	if !ok {
+		t.Fatalf("expected a *CompileError, but got %v", err)
+	}
+	if len(compileErr.Errors) != 3 {
+		t.Errorf("expected 3 errors, but got %d: %v", len(compileErr.Errors), err)
+	}
+}
+
+func TestCompile_unterminated_call(t *testing.T) {
+	for _, exp := range []string{"S(", "IF(", "AL(S(\"a\"),", "K(1", "S(\"a\") + IF("} {
+		if _, err := Compile(exp); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%s: expected a syntax error, but got %v", exp, err)
+		}
+		if _, err := Bend(exp, map[string]interface{}{"a": 1}); err == nil {
+			t.Errorf("%s: expected Bend to fail", exp)
+		}
+	}
+}
+
+func TestBend_eval_errors(t *testing.T) {
+	mapping := map[string]interface{}{
+		"next":  "S(\"age\") + 1",
+		"adult": "IF(S(\"age\") >= 18, \"yes\", \"no\")",
+		"tag":   "F(\"func(v interface{}, args ...interface{}) interface{} { return v.(map[string]interface{})[\\\"tags\\\"].([]string)[0] }\")",
+	}
+	bender, err := Compile(mapping)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	records := []map[string]interface{}{
+		{"age": 20, "tags": []string{"a"}},
+		{"age": "twenty", "tags": []string{"a"}},
+		{"age": 20},
+	}
+	for i, record := range records {
+		_, err := bender.Bend(record, nil)
+		if i == 0 {
+			if err != nil {
+				t.Errorf("record %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("record %d: expected an error", i)
+		}
+	}
+
+	_, err = bender.Bend(records[1], nil)
+	if !strings.Contains(err.Error(), "IF(S(\"age\") >= 18") || !strings.Contains(err.Error(), "^") {
+		t.Errorf("expected the error to show the expression and position, but got %v", err)
+	}
+}
+
+func TestBend_error_path(t *testing.T) {
+	mapping := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"stars": []interface{}{
+				"K(1)",
+				"K(2)",
+				map[string]interface{}{
+					"param": map[string]interface{}{"name": "S(\"owner\", \"name\")"},
+				},
+			},
+		},
+	}
+	_, err := Bend(mapping, map[string]interface{}{"owner": map[string]interface{}{"id": 1}})
+	var bendErr *BendError
+	if !errors.As(err, &bendErr) {
+		t.Fatalf("expected a *BendError, but got %v", err)
+	}
+	if bendErr.Key != "/spec/stars/2/param/name" {
+		t.Errorf("expected key /spec/stars/2/param/name, but got %s", bendErr.Key)
+	}
+	if bendErr.Expression != "S(\"owner\", \"name\")" {
+		t.Errorf("expected the mapping expression, but got %s", bendErr.Expression)
+	}
+	if want := []interface{}{"owner", "name"}; !reflect.DeepEqual(bendErr.Path, want) {
+		t.Errorf("expected source path %v, but got %v", want, bendErr.Path)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found error, but got %v", err)
+	}
+
+	_, err = Compile(map[string]interface{}{"a": []interface{}{"K(1)", "1 +"}})
+	if !errors.As(err, &bendErr) || bendErr.Key != "/a/1" || bendErr.Expression != "1 +" {
+		t.Errorf("expected a compile error for /a/1, but got %v", err)
+	}
+}
+
+func TestBend_selector_value(t *testing.T) {
+	name, _ := NewS("name")
+	mapping := map[string]interface{}{
+		"name":  name,
+		"kind":  &K{"VIP"},
+		"pets":  []interface{}{NewAlternation(&S{Path: []interface{}{"pets", 5}}, &S{Path: []interface{}{"pets", 0}})},
+		"label": "S(\"name\")",
+	}
+	source := map[string]interface{}{"name": "Bob", "pets": []interface{}{"cat"}}
+
+	// selectors built in Go are executed, they are not copied into the result
+	output, err := Bend(mapping, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"name": "Bob", "kind": "VIP", "pets": []interface{}{"cat"}, "label": "Bob"}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+
+	missing, _ := NewS("missing")
+	_, err = Bend(map[string]interface{}{"owner": map[string]interface{}{"id": missing}}, source)
+	var bendErr *BendError
+	if !errors.As(err, &bendErr) || bendErr.Key != "/owner/id" || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the failing selector to be located, but got %v", err)
+	}
+}
+
+func TestBend_collect_errors(t *testing.T) {
+	mapping := map[string]interface{}{
+		"name": "S(\"name\")",
+		"age":  "S(\"age\") + 1",
+		"pets": []interface{}{"S(\"pets\", 0)", "S(\"pets\", 5)"},
+		"owner": map[string]interface{}{
+			"id": "S(\"owner\", \"id\")",
+		},
+	}
+	source := map[string]interface{}{"name": "Bob", "age": "old", "pets": []interface{}{"cat"}}
+
+	output, err := Bend(mapping, source, CollectErrors(nil))
+	bendErrs, ok := err.(*BendErrors)
+	if !ok {
+		t.Fatalf("expected a *BendErrors, but got %v", err)
+	}
+	var keys []string
+	for _, e := range bendErrs.Errors {
+		keys = append(keys, e.Key)
+	}
+	if want := []string{"/age", "/owner/id", "/pets/1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected failures at %v, but got %v", want, keys)
+	}
+	expect := map[string]interface{}{
+		"name":  "Bob",
+		"age":   nil,
+		"pets":  []interface{}{"cat", nil},
+		"owner": map[string]interface{}{"id": nil},
+	}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+	if !errors.Is(err, ErrTypeMismatch) || !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("expected the failure kinds to be reachable, but got %v", err)
+	}
+
+	output, _ = Bend(mapping, source, CollectErrors("N/A"))
+	if got := output.(map[string]interface{})["age"]; got != "N/A" {
+		t.Errorf("expected the placeholder, but got %v", got)
+	}
+
+	if _, err := Bend(mapping, source); !errors.As(err, new(*BendError)) {
+		t.Errorf("expected bending to stop at the first failure, but got %v", err)
+	}
+}
@@ -0,0 +1,517 @@
+package whiteboard
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Map bends its item mapping against every element of a list,
+// elements whose mapping yields no value (an IF without else) are left out
+type Map struct {
+	list    Selector
+	mapping interface{}
+}
+
+// NewMap builds a Map, itemMapping is a bend mapping: a map, a list, an expression or a Selector
+func NewMap(list Selector, itemMapping interface{}) (*Map, error) {
+	mapping, err := compileItemMapping(itemMapping)
+	if err != nil {
+		return nil, err
+	}
+	return &Map{list: list, mapping: mapping}, nil
+}
+
+func (m *Map) Execute(source interface{}) (interface{}, error) {
+	return m.executeScoped(source, rootEnv(source))
+}
+
+func (m *Map) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, err := listOf("MAP", m.list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		val, err := bendItem(m.mapping, item, i, e)
+		if errors.Is(err, errOmitted) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, val)
+	}
+	return result, nil
+}
+
+// Filter keeps the elements of a list for which its predicate mapping is true, see ConditionMode
+type Filter struct {
+	list      Selector
+	predicate interface{}
+	// Strict makes a predicate that is not a bool fail with ErrTypeMismatch
+	Strict bool
+}
+
+// NewFilter builds a Filter following ConditionMode, predicate is a bend mapping
+func NewFilter(list Selector, predicate interface{}) (*Filter, error) {
+	mapping, err := compileItemMapping(predicate)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{list: list, predicate: mapping, Strict: ConditionMode == StrictConditionMode}, nil
+}
+
+func (f *Filter) Execute(source interface{}) (interface{}, error) {
+	return f.executeScoped(source, rootEnv(source))
+}
+
+func (f *Filter) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, err := listOf("FILTER", f.list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		val, err := bendItem(f.predicate, item, i, e)
+		if err != nil {
+			return nil, err
+		}
+		keep := valueOf(val).truthy()
+		if f.Strict {
+			if keep, err = toBool("FILTER", val); err != nil {
+				return nil, err
+			}
+		}
+		if keep {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// Reduce folds a list into one value, its combiner mapping is bent against
+// a *ReduceStep holding the value so far and the current element
+type Reduce struct {
+	list     Selector
+	init     interface{}
+	combiner interface{}
+}
+
+// ReduceStep is what the combiner of a Reduce is bent against,
+// a mapping reads it as S("acc"), S("item") and S("index")
+type ReduceStep struct {
+	Acc   interface{} `json:"acc"`
+	Item  interface{} `json:"item"`
+	Index int         `json:"index"`
+}
+
+// NewReduce builds a Reduce starting from init, a literal or a Selector executed against the source
+func NewReduce(list Selector, init interface{}, combiner interface{}) (*Reduce, error) {
+	mapping, err := compileItemMapping(combiner)
+	if err != nil {
+		return nil, err
+	}
+	return &Reduce{list: list, init: init, combiner: mapping}, nil
+}
+
+func (r *Reduce) Execute(source interface{}) (interface{}, error) {
+	return r.executeScoped(source, rootEnv(source))
+}
+
+func (r *Reduce) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, err := listOf("REDUCE", r.list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	acc := r.init
+	if sel, ok := acc.(Selector); ok {
+		if acc, err = execute(sel, source, e); err != nil {
+			return nil, err
+		}
+	}
+	for i, item := range items {
+		if acc, err = bendItem(r.combiner, &ReduceStep{Acc: acc, Item: item, Index: i}, i, e); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+// ForAll bends its item mapping against every element of a list like Map, and binds
+// the variables `$item`, `$index` and `$parent`, the source the ForAll runs against,
+// for the expressions of the mapping. `$root` is still the source of the whole Bend
+// and the variables of an enclosing ForAll stay visible unless they are bound again,
+// e.g. `S("qty") * $root.rates.vat` joins an element back to the source of the Bend
+type ForAll struct {
+	list    Selector
+	mapping interface{}
+}
+
+// NewForAll builds a ForAll, itemMapping is a bend mapping like the one of NewMap
+func NewForAll(list Selector, itemMapping interface{}) (*ForAll, error) {
+	mapping, err := compileItemMapping(itemMapping)
+	if err != nil {
+		return nil, err
+	}
+	return &ForAll{list: list, mapping: mapping}, nil
+}
+
+func (f *ForAll) Execute(source interface{}) (interface{}, error) {
+	return f.executeScoped(source, rootEnv(source))
+}
+
+func (f *ForAll) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, err := listOf("FORALL", f.list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		vars := map[string]interface{}{"$item": item, "$index": i, "$parent": source}
+		val, err := bendItem(f.mapping, item, i, e.with(vars))
+		if errors.Is(err, errOmitted) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, val)
+	}
+	return result, nil
+}
+
+// SortBy sorts a list by the value of key for each element, ascending unless Desc.
+// Keys compare like `<` does and elements with equal keys keep their order
+type SortBy struct {
+	list Selector
+	key  Selector
+	Desc bool
+}
+
+// NewSortBy builds a SortBy, a nil key sorts the elements by themselves
+func NewSortBy(list Selector, key Selector, desc bool) *SortBy {
+	return &SortBy{list: list, key: key, Desc: desc}
+}
+
+func (s *SortBy) Execute(source interface{}) (interface{}, error) {
+	return s.executeScoped(source, rootEnv(source))
+}
+
+func (s *SortBy) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, keys, err := keyedListOf("SORTBY", s.list, s.key, source, e)
+	if err != nil {
+		return nil, err
+	}
+	op := "<"
+	if s.Desc {
+		op = ">"
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		less, cmpErr := compare(op, keys[order[i]], keys[order[j]])
+		if cmpErr != nil && err == nil {
+			err = cmpErr
+		}
+		return less
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, len(items))
+	for i, k := range order {
+		result[i] = items[k]
+	}
+	return result, nil
+}
+
+// GroupBy splits a list into the lists of elements sharing the same value of key,
+// the groups are keyed by the string form of that value like JSON object keys
+type GroupBy struct {
+	list Selector
+	key  Selector
+}
+
+// NewGroupBy builds a GroupBy
+func NewGroupBy(list Selector, key Selector) *GroupBy {
+	return &GroupBy{list: list, key: key}
+}
+
+func (g *GroupBy) Execute(source interface{}) (interface{}, error) {
+	return g.executeScoped(source, rootEnv(source))
+}
+
+func (g *GroupBy) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, keys, err := keyedListOf("GROUPBY", g.list, g.key, source, e)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	for i, item := range items {
+		k := fmt.Sprint(keys[i].Interface())
+		group, _ := result[k].([]interface{})
+		result[k] = append(group, item)
+	}
+	return result, nil
+}
+
+// Unique keeps the first element of a list for every value of key, values are compared like `==` does
+type Unique struct {
+	list Selector
+	key  Selector
+}
+
+// NewUnique builds a Unique, a nil key compares the elements themselves
+func NewUnique(list Selector, key Selector) *Unique {
+	return &Unique{list: list, key: key}
+}
+
+func (u *Unique) Execute(source interface{}) (interface{}, error) {
+	return u.executeScoped(source, rootEnv(source))
+}
+
+func (u *Unique) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, keys, err := keyedListOf("UNIQUE", u.list, u.key, source, e)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(items))
+	seen := make([]value, 0, len(items))
+	for i, item := range items {
+		duplicate := false
+		for _, k := range seen {
+			if equal, err := compare("==", keys[i], k); err == nil && equal {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			seen = append(seen, keys[i])
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// Flatten splices the lists nested in a list into it, Depth levels deep or completely when Depth < 0
+type Flatten struct {
+	list  Selector
+	Depth int
+}
+
+// NewFlatten builds a Flatten
+func NewFlatten(list Selector, depth int) *Flatten {
+	return &Flatten{list: list, Depth: depth}
+}
+
+func (f *Flatten) Execute(source interface{}) (interface{}, error) {
+	return f.executeScoped(source, rootEnv(source))
+}
+
+func (f *Flatten) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, err := listOf("FLATTEN", f.list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	return flatten(make([]interface{}, 0, len(items)), items, f.Depth), nil
+}
+
+func flatten(result []interface{}, items []interface{}, depth int) []interface{} {
+	for _, item := range items {
+		if v := valueOf(item); v.kind == listKind && depth != 0 {
+			result = flatten(result, v.list(), depth-1)
+		} else {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Zip pairs the elements of its lists by index into lists, it stops at the end of the shortest one.
+// Without lists it yields an empty list
+type Zip struct {
+	lists []Selector
+}
+
+// NewZip builds a Zip
+func NewZip(lists ...Selector) *Zip {
+	return &Zip{lists: lists}
+}
+
+func (z *Zip) Execute(source interface{}) (interface{}, error) {
+	return z.executeScoped(source, rootEnv(source))
+}
+
+func (z *Zip) executeScoped(source interface{}, e env) (interface{}, error) {
+	columns := make([][]interface{}, len(z.lists))
+	n := 0
+	for i, list := range z.lists {
+		items, err := listOf("ZIP", list, source, e)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = items
+		if i == 0 || len(items) < n {
+			n = len(items)
+		}
+	}
+	result := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		row := make([]interface{}, len(columns))
+		for j, column := range columns {
+			row[j] = column[i]
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// Slice returns the elements of a list from Start up to but excluding End.
+// Like Python, a negative index counts from the end and indexes past either end are clamped,
+// so an End of math.MaxInt slices to the end
+type Slice struct {
+	list  Selector
+	Start int
+	End   int
+}
+
+// NewSlice builds a Slice
+func NewSlice(list Selector, start, end int) *Slice {
+	return &Slice{list: list, Start: start, End: end}
+}
+
+func (s *Slice) Execute(source interface{}) (interface{}, error) {
+	return s.executeScoped(source, rootEnv(source))
+}
+
+func (s *Slice) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, err := listOf("SLICE", s.list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	start, end := clampIndex(s.Start, len(items)), clampIndex(s.End, len(items))
+	if start >= end {
+		return []interface{}{}, nil
+	}
+	return items[start:end], nil
+}
+
+// clampIndex resolves a Python style index into [0, n]
+func clampIndex(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+// Count returns the number of elements of a list or entries of a map
+type Count struct {
+	list Selector
+}
+
+// NewCount builds a Count
+func NewCount(list Selector) *Count {
+	return &Count{list: list}
+}
+
+func (c *Count) Execute(source interface{}) (interface{}, error) {
+	return c.executeScoped(source, rootEnv(source))
+}
+
+func (c *Count) executeScoped(source interface{}, e env) (interface{}, error) {
+	items, err := listOf("COUNT", c.list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	return len(items), nil
+}
+
+// compileItemMapping compiles the mapping a collection selector bends against each element
+func compileItemMapping(mapping interface{}) (interface{}, error) {
+	errs := &CompileError{}
+	compiled := compileMapping(mapping, "", errs)
+	if len(errs.Errors) > 0 {
+		return nil, errs
+	}
+	return compiled, nil
+}
+
+// listOf executes the list selector of the collection selector name,
+// a map stands for the list of its values in the order of its sorted keys
+func listOf(name string, list Selector, source interface{}, e env) ([]interface{}, error) {
+	v, err := execute(list, source, e)
+	if err != nil {
+		return nil, err
+	}
+	lv := valueOf(v)
+	if lv.kind == listKind {
+		return lv.list(), nil
+	}
+	if rv := indirect(reflect.ValueOf(v)); rv.Kind() == reflect.Map {
+		items := make([]interface{}, 0, rv.Len())
+		for _, k := range sortedMapKeys(rv) {
+			items = append(items, rv.MapIndex(k).Interface())
+		}
+		return items, nil
+	}
+	return nil, errorOf(ErrTypeMismatch, "%s wants a list but get %T", name, v)
+}
+
+// keyedListOf is listOf along with the value of key for every element, a nil key is the element itself
+func keyedListOf(name string, list Selector, key Selector, source interface{}, e env) ([]interface{}, []value, error) {
+	items, err := listOf(name, list, source, e)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]value, len(items))
+	for i, item := range items {
+		k := item
+		if key != nil {
+			if k, err = execute(key, item, e); err != nil {
+				return nil, nil, err
+			}
+		}
+		keys[i] = valueOf(k)
+	}
+	return items, keys, nil
+}
+
+// bendItem bends a compiled item mapping against the element at index i within e.
+// The element sees the context and options of the mapping being bent, and the key of a
+// *BendError, returned or collected, is rebased onto the JSON pointer of the element
+func bendItem(mapping interface{}, item interface{}, i int, e env) (interface{}, error) {
+	transport := NewTransport(item, make(map[interface{}]interface{}))
+	if e.transport != nil {
+		transport.context = e.transport.context
+		transport.options = e.transport.options
+		transport.errs = e.transport.errs
+	}
+	transport.scope = e.scope
+	if e.trace != nil {
+		transport.trace = &Trace{Key: jsonPointer("", i), Node: "item"}
+		e.trace.Children = append(e.trace.Children, transport.trace)
+	}
+	n := len(transport.errs.Errors)
+	val, err := _bend(mapping, transport, "")
+	if transport.trace != nil {
+		transport.trace.Value, transport.trace.Err = val, err
+	}
+	transport.rebase(n, jsonPointer("", i))
+	var bendErr *BendError
+	if errors.As(err, &bendErr) {
+		located := *bendErr
+		located.Key = jsonPointer("", i) + bendErr.Key
+		return nil, &located
+	}
+	return val, err
+}
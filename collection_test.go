@@ -0,0 +1,348 @@
+package whiteboard
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var collectionSource = map[string]interface{}{
+	"users": []interface{}{
+		map[string]interface{}{"name": "Ann", "age": 31, "admin": true},
+		map[string]interface{}{"name": "Bob", "age": 12, "admin": false},
+		map[string]interface{}{"name": "Cid", "age": 45, "admin": false},
+	},
+	"prices": []float64{1.5, 2, 3.5},
+	"name":   "shop",
+}
+
+func TestMap_Execute(t *testing.T) {
+	users, _ := NewS("users")
+	m, err := NewMap(users, map[string]interface{}{
+		"id":    "S(\"name\")",
+		"adult": "S(\"age\") >= 18",
+		"admin": "IF(S(\"admin\"), K(true))",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result, err := m.Execute(collectionSource)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []interface{}{
+		map[string]interface{}{"id": "Ann", "adult": true, "admin": true},
+		map[string]interface{}{"id": "Bob", "adult": false},
+		map[string]interface{}{"id": "Cid", "adult": true},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("expected %v but got %v", want, result)
+	}
+
+	name, _ := NewS("name")
+	m, _ = NewMap(name, "S(\"x\")")
+	if _, err := m.Execute(collectionSource); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected a type mismatch but got %v", err)
+	}
+	m, _ = NewMap(users, "S(\"missing\")")
+	_, err = m.Execute(collectionSource)
+	var bendErr *BendError
+	if !errors.As(err, &bendErr) || bendErr.Key != "/0" || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a not found error at /0 but got %v", err)
+	}
+	if _, err := NewMap(users, "S(\"missing\""); err == nil {
+		t.Errorf("expected a compile error")
+	}
+}
+
+func TestFilter_Reduce_Execute(t *testing.T) {
+	users, _ := NewS("users")
+	f, _ := NewFilter(users, "S(\"age\") >= 18 && !S(\"admin\")")
+	result, err := f.Execute(collectionSource)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if items := result.([]interface{}); len(items) != 1 || items[0].(map[string]interface{})["name"] != "Cid" {
+		t.Errorf("expected only Cid but got %v", result)
+	}
+
+	prices, _ := NewS("prices")
+	r, _ := NewReduce(prices, 0, "S(\"acc\") + S(\"item\")")
+	if total, err := r.Execute(collectionSource); err != nil || total != 7.0 {
+		t.Errorf("expected 7 but got %v (%v)", total, err)
+	}
+	name, _ := NewS("name")
+	r, _ = NewReduce(users, name, "S(\"acc\") + \",\" + S(\"item\", \"name\")")
+	if joined, err := r.Execute(collectionSource); err != nil || joined != "shop,Ann,Bob,Cid" {
+		t.Errorf("expected shop,Ann,Bob,Cid but got %v (%v)", joined, err)
+	}
+}
+
+func TestCollection_mapping(t *testing.T) {
+	mapping := map[string]interface{}{
+		"names":  "MAP(S(\"users\"), S(\"name\"))",
+		"adults": "map(filter(S(\"users\"), S(\"age\") >= 18), S(\"name\"))",
+		"total":  "REDUCE(S(\"prices\"), 0, S(\"acc\") + S(\"item\"))",
+		"count":  "reduce(S(\"users\"), 0, S(\"acc\") + 1)",
+	}
+	output, err := Bend(mapping, collectionSource)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{
+		"names":  []interface{}{"Ann", "Bob", "Cid"},
+		"adults": []interface{}{"Ann", "Cid"},
+		"total":  7.0,
+		"count":  int64(3),
+	}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+
+	for _, exp := range []string{"MAP(S(\"users\"))", "REDUCE(S(\"users\"), 0)"} {
+		if _, err := Compile(exp); err == nil {
+			t.Errorf("%s: expected a syntax error", exp)
+		}
+	}
+}
+
+func TestForAll_Execute(t *testing.T) {
+	source := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name":  "sol",
+			"stars": []interface{}{map[string]interface{}{"name": "a"}, map[string]interface{}{"name": "b"}},
+		},
+		"units": "ly",
+	}
+	mapping := map[string]interface{}{
+		"stars": "FORALL(S(\"spec\", \"stars\"), $item.name + \"@\" + $root.spec.name)",
+		"ranks": "forall(S(\"spec\", \"stars\"), $index + 1)",
+	}
+	output, err := Bend(mapping, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{
+		"stars": []interface{}{"a@sol", "b@sol"},
+		"ranks": []interface{}{int64(1), int64(2)},
+	}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+
+	stars, _ := NewS("spec", "stars")
+	f, err := NewForAll(stars, map[string]interface{}{
+		"name":  "S(\"name\")",
+		"index": "$index",
+		"units": "$root.units",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	result, err := f.Execute(source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []interface{}{
+		map[string]interface{}{"name": "a", "index": 0, "units": "ly"},
+		map[string]interface{}{"name": "b", "index": 1, "units": "ly"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("expected %v but got %v", want, result)
+	}
+}
+
+func TestForAll_scope(t *testing.T) {
+	source := map[string]interface{}{
+		"rate":     2,
+		"currency": "eur",
+		"orders": []interface{}{
+			map[string]interface{}{"id": "o1", "lines": []interface{}{map[string]interface{}{"price": 3}, map[string]interface{}{"price": 5}}},
+			map[string]interface{}{"id": "o2", "lines": []interface{}{map[string]interface{}{"price": 7}}},
+		},
+	}
+	testCases := []struct {
+		exp  string
+		want interface{}
+	}{
+		// an element path mixed with a variable
+		{"FORALL(S(\"orders\", 0, \"lines\"), S(\"price\") * $root.rate)", []interface{}{int64(6), int64(10)}},
+		// `$root` is the source of the Bend in a nested ForAll, `$parent` the order
+		{"FORALL(S(\"orders\"), FORALL(S(\"lines\"), $parent.id + \":\" + $root.currency))", []interface{}{[]interface{}{"o1:eur", "o1:eur"}, []interface{}{"o2:eur"}}},
+		// the variables of the outer ForAll stay visible, even inside a MAP
+		{"FORALL(S(\"orders\"), MAP(S(\"lines\"), $index * 10 + S(\"price\")))", []interface{}{[]interface{}{int64(3), int64(5)}, []interface{}{int64(17)}}},
+		{"FORALL(S(\"orders\"), FORALL(S(\"lines\"), $index))", []interface{}{[]interface{}{0, 1}, []interface{}{0}}},
+	}
+	for _, tc := range testCases {
+		output, err := Bend(tc.exp, source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+			continue
+		}
+		if !reflect.DeepEqual(output, tc.want) {
+			t.Errorf("%s: expected %v, but got %v", tc.exp, tc.want, output)
+		}
+	}
+
+	// the failure is the one of the element, not of a second attempt against another value
+	_, err := Bend("FORALL(S(\"orders\"), S(\"total\") * $root.rate)", source)
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "no such key total") {
+		t.Errorf("expected the missing total to be reported, but got %v", err)
+	}
+	_, err = Bend("FORALL(S(\"orders\"), $item.id + $missing)", source)
+	if !strings.Contains(err.Error(), "variable $missing is not bound") {
+		t.Errorf("expected the unbound variable to be reported, but got %v", err)
+	}
+}
+
+func TestMap_transport(t *testing.T) {
+	source := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "Ann", "age": 31},
+			map[string]interface{}{"name": "Bob", "age": "old"},
+		},
+	}
+	users, _ := NewS("users")
+
+	// the context of the Bend is consulted by the expressions of the elements
+	tenants, _ := NewMap(users, "S(\"tenant\")")
+	context := map[interface{}]interface{}{"tenant": "acme"}
+	output, err := Bend(map[string]interface{}{"tenants": tenants}, source, context)
+	if want := map[string]interface{}{"tenants": []interface{}{"acme", "acme"}}; err != nil || !reflect.DeepEqual(output, want) {
+		t.Errorf("expected %v, but got %v (%v)", want, output, err)
+	}
+
+	// so is CollectErrors, a failing element key is located from the root of the mapping
+	items, _ := NewMap(users, map[string]interface{}{"name": "S(\"name\")", "next": "S(\"age\") + 1"})
+	output, err = Bend(map[string]interface{}{"team": map[string]interface{}{"users": items}}, source, CollectErrors(nil))
+	bendErrs, ok := err.(*BendErrors)
+	if !ok {
+		t.Fatalf("expected a *BendErrors, but got %v", err)
+	}
+	var keys []string
+	for _, e := range bendErrs.Errors {
+		keys = append(keys, e.Key)
+	}
+	if want := []string{"/team/users/1/next"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected failures at %v, but got %v", want, keys)
+	}
+	expect := map[string]interface{}{"team": map[string]interface{}{"users": []interface{}{
+		map[string]interface{}{"name": "Ann", "next": int64(32)},
+		map[string]interface{}{"name": "Bob", "next": nil},
+	}}}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+
+	output, err = Bend(map[string]interface{}{"next": "MAP(S(\"users\"), S(\"age\") + 1)"}, source, CollectErrors(nil))
+	bendErrs, ok = err.(*BendErrors)
+	if !ok || len(bendErrs.Errors) != 1 || bendErrs.Errors[0].Key != "/next/1" {
+		t.Errorf("expected the failing element to be located, but got %v", err)
+	}
+	if want := map[string]interface{}{"next": nil}; !reflect.DeepEqual(output, want) {
+		t.Errorf("expected the whole expression to fail, but got %v", output)
+	}
+
+	// without CollectErrors the failure is located the same way, in a single *BendError
+	stars, _ := NewS("spec", "stars")
+	out, _ := NewMap(stars, map[string]interface{}{"v": &S{Path: []interface{}{"n"}}})
+	src := map[string]interface{}{"spec": map[string]interface{}{"stars": []interface{}{
+		map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}, map[string]interface{}{},
+	}}}
+	_, err = Bend(map[string]interface{}{"out": out}, src)
+	var bendErr *BendError
+	if !errors.As(err, &bendErr) || bendErr.Key != "/out/2/v" || strings.Count(err.Error(), "Error for key") != 1 {
+		t.Errorf("expected a single error for /out/2/v, but got %v", err)
+	}
+}
+
+func TestCollection_utilities(t *testing.T) {
+	users, _ := NewS("users")
+	age, _ := NewS("age")
+	admin, _ := NewS("admin")
+	prices, _ := NewS("prices")
+	names := func(v interface{}) []interface{} {
+		var out []interface{}
+		for _, u := range v.([]interface{}) {
+			out = append(out, u.(map[string]interface{})["name"])
+		}
+		return out
+	}
+
+	testCases := []struct {
+		name     string
+		selector Selector
+		want     interface{}
+		names    bool
+	}{
+		{"sort", NewSortBy(users, age, false), []interface{}{"Bob", "Ann", "Cid"}, true},
+		{"sort desc", NewSortBy(users, age, true), []interface{}{"Cid", "Ann", "Bob"}, true},
+		{"sort stable", NewSortBy(users, &K{1}, true), []interface{}{"Ann", "Bob", "Cid"}, true},
+		{"unique", NewUnique(users, admin), []interface{}{"Ann", "Bob"}, true},
+		{"slice", NewSlice(users, 1, math.MaxInt), []interface{}{"Bob", "Cid"}, true},
+		{"slice negative", NewSlice(users, -2, -1), []interface{}{"Bob"}, true},
+		{"slice empty", NewSlice(prices, 2, 1), []interface{}{}, false},
+		{"count", NewCount(users), 3, false},
+		{"unique values", NewUnique(&K{[]interface{}{1, 1.0, "1", 2}}, nil), []interface{}{1, "1", 2}, false},
+		{"flatten", NewFlatten(&K{[]interface{}{1, []interface{}{2, []int{3}}}}, 1), []interface{}{1, 2, []int{3}}, false},
+		{"flatten all", NewFlatten(&K{[]interface{}{1, []interface{}{2, []int{3}}}}, -1), []interface{}{1, 2, 3}, false},
+		{"zip", NewZip(prices, &K{[]string{"a", "b"}}), []interface{}{[]interface{}{1.5, "a"}, []interface{}{2.0, "b"}}, false},
+		{"zip nothing", NewZip(), []interface{}{}, false},
+		{"map values", NewCount(&K{map[string]int{"a": 1, "b": 2}}), 2, false},
+		{"group", NewGroupBy(prices, &K{"all"}), map[string]interface{}{"all": []interface{}{1.5, 2.0, 3.5}}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.selector.Execute(collectionSource)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.names {
+				got = names(got)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %v but got %v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := NewSortBy(&K{[]interface{}{1, "a"}}, nil, false).Execute(nil); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected a type mismatch but got %v", err)
+	}
+}
+
+func TestCollection_utilities_mapping(t *testing.T) {
+	mapping := map[string]interface{}{
+		"youngest": "SLICE(MAP(SORTBY(S(\"users\"), S(\"age\")), S(\"name\")), 0, 1)",
+		"oldest":   "map(sortby(S(\"users\"), S(\"age\"), true), S(\"name\"))",
+		"last":     "slice(S(\"prices\"), -1)",
+		"admins":   "COUNT(GROUPBY(S(\"users\"), S(\"admin\")))",
+		"flags":    "UNIQUE(MAP(S(\"users\"), S(\"admin\")))",
+		"flat":     "FLATTEN(ZIP(S(\"prices\"), MAP(S(\"users\"), S(\"name\"))))",
+		"count":    "count(S(\"users\"))",
+	}
+	output, err := Bend(mapping, collectionSource)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{
+		"youngest": []interface{}{"Bob"},
+		"oldest":   []interface{}{"Cid", "Ann", "Bob"},
+		"last":     []interface{}{3.5},
+		"admins":   2,
+		"flags":    []interface{}{true, false},
+		"flat":     []interface{}{1.5, "Ann", 2.0, "Bob", 3.5, "Cid"},
+		"count":    3,
+	}
+	if !reflect.DeepEqual(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+
+	for _, exp := range []string{"SORTBY(S(\"a\"))", "SORTBY(S(\"a\"), S(\"b\"), S(\"c\"))", "SLICE(S(\"a\"), S(\"b\"))", "COUNT()", "FLATTEN(S(\"a\"), 1.5)"} {
+		if _, err := Compile(exp); err == nil {
+			t.Errorf("%s: expected a syntax error", exp)
+		}
+	}
+}
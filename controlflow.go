@@ -2,81 +2,219 @@ package whiteboard
 
 import (
 	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
+// If selects whenTrue or whenFalse by the truth value of condition, see ConditionMode.
+// Without whenFalse a false condition yields no value and the key is dropped from the bent output
 type If struct {
 	condition Selector
 	whenTrue  Selector
 	whenFalse Selector
+	// Strict makes a condition that is not a bool fail with ErrTypeMismatch
+	Strict bool
 }
 
+// errOmitted is the result of an If without else branch whose condition is false,
+// it counts as a missing value so that `??` and Alternation fall through it
+var errOmitted = errorOf(ErrNotFound, "condition is false and there is no else branch")
+
 func (i *If) Execute(val interface{}) (interface{}, error) {
+	return i.executeScoped(val, rootEnv(val))
+}
 
-	condVal, err := i.condition.Execute(val)
+func (i *If) executeScoped(val interface{}, e env) (interface{}, error) {
+
+	condVal, err := execute(i.condition, val, e)
 	if err != nil {
 		return nil, err
 	}
-	if condVal.(bool) {
-		return i.whenTrue.Execute(val)
-	} else {
-		return i.whenFalse.Execute(val)
+	cond := valueOf(condVal).truthy()
+	if i.Strict {
+		if cond, err = toBool("IF", condVal); err != nil {
+			return nil, err
+		}
+	}
+	if cond {
+		e.trace.setBranch("then")
+		return execute(i.whenTrue, val, e)
+	}
+	e.trace.setBranch("else")
+	if i.whenFalse == nil {
+		return nil, errOmitted
 	}
+	return execute(i.whenFalse, val, e)
 }
 
+// NewIf builds an If following ConditionMode, whenFalse may be nil
 func NewIf(condition Selector, whenTrue Selector, whenFalse Selector) *If {
-	return &If{condition: condition, whenTrue: whenTrue, whenFalse: whenFalse}
+	return &If{condition: condition, whenTrue: whenTrue, whenFalse: whenFalse, Strict: ConditionMode == StrictConditionMode}
 }
 
+// Alternation returns the value of the first of its selectors that succeeds, see FallThroughMode.
+// When every selector fails the error is an *AlternationError listing all the failures
 type Alternation struct {
 	selectors []Selector
+	// NotFoundOnly makes a failure other than ErrNotFound stop the Alternation
+	// instead of trying the next selector
+	NotFoundOnly bool
 }
 
+// NewAlternation builds an Alternation following FallThroughMode
 func NewAlternation(s ...Selector) *Alternation {
-	return &Alternation{s}
+	return &Alternation{selectors: s, NotFoundOnly: FallThroughMode == NotFoundFallThrough}
 }
 
 func (a *Alternation) Execute(source interface{}) (interface{}, error) {
-	var exc error
-	for _, selector := range a.selectors {
-		result, err := selector.Execute(source)
-		// && !errors.Is(err, NotFoundError)
-		// fmt.Printf("%v -> %v -> %v \n", source, result, err)
-		if err != nil {
-			exc = err
-		} else {
+	return a.executeScoped(source, rootEnv(source))
+}
+
+func (a *Alternation) executeScoped(source interface{}, e env) (interface{}, error) {
+	errs := make([]error, 0, len(a.selectors))
+	for i, selector := range a.selectors {
+		result, err := execute(selector, source, e)
+		if err == nil {
+			e.trace.setBranch(strconv.Itoa(i))
 			return result, nil
 		}
+		if a.NotFoundOnly && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		errs = append(errs, err)
 	}
-	return nil, exc
+	return nil, &AlternationError{Errors: errs}
 }
 
+// AlternationError reports an Alternation whose selectors all failed,
+// Errors holds the failure of each selector in order
+type AlternationError struct {
+	Errors []error
+}
+
+// Is matches target only when every selector failed with it, so that an Alternation
+// is not found, e.g. for `??`, only when none of its selectors found anything
+func (e *AlternationError) Is(target error) bool {
+	if len(e.Errors) == 0 {
+		return false
+	}
+	for _, err := range e.Errors {
+		if !errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *AlternationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = fmt.Sprintf("%d: %v", i, err)
+	}
+	return fmt.Sprintf("all %d alternative(s) failed:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+// Try returns the value of its selector, or the value of fallback when the selector fails.
+// The fallback is executed against the same source, with the message of the failure bound
+// to the variable `$error`, e.g. `TRY(S("price") * S("qty"), "failed: " + $error)`
+type Try struct {
+	selector Selector
+	fallback Selector
+}
+
+// NewTry builds a Try running fallback when selector fails
+func NewTry(selector Selector, fallback Selector) *Try {
+	return &Try{selector: selector, fallback: fallback}
+}
+
+func (t *Try) Execute(source interface{}) (interface{}, error) {
+	return t.executeScoped(source, rootEnv(source))
+}
+
+func (t *Try) executeScoped(source interface{}, e env) (interface{}, error) {
+	result, err := execute(t.selector, source, e)
+	if err == nil {
+		e.trace.setBranch("try")
+		return result, nil
+	}
+	e.trace.setBranch("fallback")
+	return execute(t.fallback, source, e.with(map[string]interface{}{"$error": err.Error()}))
+}
+
+// Default returns the value of its selector, or Value when the selector finds nothing
+// (ErrNotFound) or yields nil. Any other failure is returned as is
+type Default struct {
+	selector Selector
+	Value    interface{}
+}
+
+// NewDefault builds a Default substituting value for a missing result of selector
+func NewDefault(selector Selector, value interface{}) *Default {
+	return &Default{selector: selector, Value: value}
+}
+
+func (d *Default) Execute(source interface{}) (interface{}, error) {
+	return d.executeScoped(source, rootEnv(source))
+}
+
+func (d *Default) executeScoped(source interface{}, e env) (interface{}, error) {
+	result, err := execute(d.selector, source, e)
+	if (err == nil && result == nil) || errors.Is(err, ErrNotFound) {
+		e.trace.setBranch("default")
+		return d.Value, nil
+	}
+	return result, err
+}
+
+// Switch picks the selector of the case equal to the value of its key selector.
+// Keys are compared like `==` does, so the int 1 of a source matches the case 1 of a mapping
 type Switch struct {
 	keySelctor      Selector
 	cases           map[interface{}]Selector
 	defaultSelector Selector
 }
 
-func (s *Switch) Execute(source map[interface{}]interface{}) (interface{}, error) {
+// NewSwitch builds a Switch, defaultSelector may be nil to fail when no case matches
+func NewSwitch(key Selector, cases map[interface{}]Selector, defaultSelector Selector) *Switch {
+	return &Switch{keySelctor: key, cases: cases, defaultSelector: defaultSelector}
+}
+
+func (s *Switch) Execute(source interface{}) (interface{}, error) {
+	return s.executeScoped(source, rootEnv(source))
+}
 
-	key, err := s.keySelctor.Execute(source)
+func (s *Switch) executeScoped(source interface{}, e env) (interface{}, error) {
+	key, err := execute(s.keySelctor, source, e)
 	if err != nil {
 		return nil, err
 	}
 
-	var benderFn Selector
-	var ok bool
-	if benderFn, ok = s.cases[key]; !ok {
-		if s.defaultSelector != nil {
-			benderFn = s.defaultSelector
-		} else {
-			return nil, errors.New("key not found in case container")
+	benderFn, ok := s.match(key)
+	if !ok {
+		if s.defaultSelector == nil {
+			return nil, errorOf(ErrNotFound, "key %v not found in case container", key)
 		}
+		e.trace.setBranch("default")
+		benderFn = s.defaultSelector
+	} else {
+		e.trace.setBranch(fmt.Sprintf("case %v", key))
 	}
+	return execute(benderFn, source, e)
+}
 
-	val, err := benderFn.Execute(source)
-	if err != nil {
-		return nil, err
+// match looks the case of key up, exactly first and then by value in a stable order
+func (s *Switch) match(key interface{}) (Selector, bool) {
+	if key != nil && reflect.TypeOf(key).Comparable() {
+		if sel, ok := s.cases[key]; ok {
+			return sel, true
+		}
 	}
-
-	return val, nil
+	for _, k := range sortedMapKeys(reflect.ValueOf(s.cases)) {
+		if equal, err := compare("==", valueOf(key), valueOf(k.Interface())); err == nil && equal {
+			return s.cases[k.Interface()], true
+		}
+	}
+	return nil, false
 }
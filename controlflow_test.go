@@ -3,6 +3,7 @@ package whiteboard
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -128,58 +129,277 @@ func TestAlternation_Execute_dict(t *testing.T) {
 	}
 }
 
-/**
 func TestSwitch_Execute_Example(t *testing.T) {
 	// create Switch object with cases for 'twitter' and 'mastodon', and default case for 'email'
 	ss, _ := NewS("service")
-	sh, _ = NewS("handle")
-	k, _ := NewK("@")
-	s, _ := NewS("server")
-
+	sh, _ := NewS("handle")
 	se, _ := NewS("email")
+	toks, _ := Parse("S(\"handle\") + \"@\" + S(\"server\")")
+	full := &exprSelector{expr: NewAST(toks, "").ParseExpression()}
 
-	switchSelector := &Switch{
-		keySelctor: ss,
-		cases: map[interface{}]selector{
-			"twitter":  sh,
-			"mastodon": sh + k + s,
-		},
-		defaultSelector: se,
+	switchSelector := NewSwitch(ss, map[interface{}]Selector{
+		"twitter":  sh,
+		"mastodon": full,
+	}, se)
+
+	testCases := []struct {
+		source map[interface{}]interface{}
+		want   interface{}
+	}{
+		// test case for 'twitter' service
+		{map[interface{}]interface{}{"service": "twitter", "handle": "etandel"}, "etandel"},
+		// test case for 'mastodon' service
+		{map[interface{}]interface{}{"service": "mastodon", "handle": "etandel", "server": "mastodon.social"}, "etandel@mastodon.social"},
+		// test default case for 'facebook' service
+		{map[interface{}]interface{}{"service": "facebook", "email": "email@whatever.com"}, "email@whatever.com"},
+	}
+	for _, tc := range testCases {
+		result, err := switchSelector.Execute(tc.source)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if result != tc.want {
+			t.Errorf("Expected result to be %v but got %v", tc.want, result)
+		}
+	}
+
+	noDefault := NewSwitch(ss, map[interface{}]Selector{"twitter": sh}, nil)
+	if _, err := noDefault.Execute(map[string]interface{}{"service": "facebook"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a not found error but got %v", err)
 	}
+}
 
-	// test case for 'twitter' service
-	source := map[interface{}]interface{}{"service": "twitter", "handle": "etandel"}
-	expectedResult := "etandel"
-	result, err := switchSelector.Execute(source)
+func TestSwitch_mapping(t *testing.T) {
+	mapping := map[string]interface{}{
+		"kind":  "SW(S(\"type\"), \"a\", K(1), \"b\", K(2), K(0))",
+		"level": "SW(S(\"rank\"), 1, \"gold\", 2, \"silver\")",
+		"other": "sw(S(\"type\"), \"x\", K(1), S(\"rank\") * 10)",
+		"sign":  "SW(S(\"delta\"), -1, \"neg\", K(1), \"pos\", \"zero\")",
+	}
+	output, err := Bend(mapping, map[string]interface{}{"type": "b", "rank": 1, "delta": -1})
 	if err != nil {
-		t.Errorf("Unexpected error: %v", err)
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"kind": int64(2), "level": "gold", "other": int64(10), "sign": "neg"}
+	if !CompareMaps(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+
+	for _, exp := range []string{"SW(S(\"type\"))", "SW(S(\"type\"), S(\"a\"), K(1))"} {
+		if _, err := Compile(exp); err == nil {
+			t.Errorf("%s: expected a syntax error", exp)
+		}
+	}
+
+	// the error points at the case value, not at the end of the call
+	_, err = Compile("SW(S(\"type\"), \"a\", K(1), S(\"b\"), K(2))")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Offset != 25 {
+		t.Errorf("expected a syntax error at offset 25, but got %v", err)
+	}
+}
+
+func TestIf_truthiness(t *testing.T) {
+	source := map[string]interface{}{
+		"zero": 0, "one": 1.5, "empty": "", "text": "a", "none": nil,
+		"list": []int{}, "full": []int{1}, "dict": map[string]int{}, "yes": true,
 	}
-	if result != expectedResult {
-		t.Errorf("Expected result to be %v but got %v", expectedResult, result)
+	testCases := []struct {
+		key  string
+		want interface{}
+	}{
+		{"zero", "no"}, {"one", "yes"}, {"empty", "no"}, {"text", "yes"}, {"none", "no"},
+		{"list", "no"}, {"full", "yes"}, {"dict", "no"}, {"yes", "yes"},
+	}
+	for _, tc := range testCases {
+		cond, _ := NewS(tc.key)
+		i := NewIf(cond, &K{"yes"}, &K{"no"})
+		result, err := i.Execute(source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.key, err)
+		}
+		if result != tc.want {
+			t.Errorf("%s: expected %v but got %v", tc.key, tc.want, result)
+		}
 	}
 
-	// test case for 'mastodon' service
-	source = map[interface{}]interface{}{"service": "mastodon", "handle": "etandel", "server": "mastodon.social"}
-	expectedResult = "etandel@mastodon.social"
-	result, err = switchSelector.Execute(source)
+	ConditionMode = StrictConditionMode
+	defer func() { ConditionMode = TruthyConditionMode }()
+	cond, _ := NewS("one")
+	if _, err := NewIf(cond, &K{"yes"}, &K{"no"}).Execute(source); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected a type mismatch in strict mode but got %v", err)
+	}
+	cond, _ = NewS("yes")
+	if result, err := NewIf(cond, &K{"yes"}, &K{"no"}).Execute(source); err != nil || result != "yes" {
+		t.Errorf("expected yes in strict mode but got %v (%v)", result, err)
+	}
+}
+
+func TestIf_without_else(t *testing.T) {
+	mapping := map[string]interface{}{
+		"name":  "S(\"name\")",
+		"adult": "IF(S(\"age\") >= 18, K(true))",
+		"tags":  []interface{}{"K(\"user\")", "IF(S(\"admin\"), K(\"admin\"))"},
+		"role":  "IF(S(\"admin\"), K(\"admin\")) ?? K(\"user\")",
+	}
+	output, err := Bend(mapping, map[string]interface{}{"name": "Bob", "age": 12, "admin": false})
 	if err != nil {
-		t.Errorf("Unexpected error: %v", err)
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"name": "Bob", "tags": []interface{}{"user"}, "role": "user"}
+	if !CompareMaps(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+}
+
+func TestAlternation_errors(t *testing.T) {
+	missing, _ := NewS("missing")
+	toks, _ := Parse("S(\"n\") / 0")
+	divide := &exprSelector{expr: NewAST(toks, "").ParseExpression()}
+	name, _ := NewS("name")
+	source := map[string]interface{}{"n": 1, "name": "Bob"}
+
+	_, err := NewAlternation(divide, missing).Execute(source)
+	var ae *AlternationError
+	if !errors.As(err, &ae) || len(ae.Errors) != 2 {
+		t.Fatalf("expected an AlternationError with 2 failures but got %v", err)
+	}
+	if !errors.Is(ae.Errors[0], ErrDivisionByZero) || !errors.Is(ae.Errors[1], ErrNotFound) {
+		t.Errorf("unexpected failures %v", ae.Errors)
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected the aggregated error to match a kind only when every failure has it: %v", err)
+	}
+	if _, err := NewAlternation(missing, missing).Execute(source); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found when every alternative is missing, but got %v", err)
 	}
-	if result != expectedResult {
-		t.Errorf("Expected result to be %v but got %v", expectedResult, result)
+	if result, err := NewAlternation(divide, name).Execute(source); err != nil || result != "Bob" {
+		t.Errorf("expected Bob but got %v (%v)", result, err)
 	}
 
-	// test default case for 'facebook' service
-	source = map[interface{}]interface{}{"service": "facebook", "email": "email@whatever.com"}
-	expectedResult = "email@whatever.com"
-	result, err = switchSelector.Execute(source)
+	FallThroughMode = NotFoundFallThrough
+	defer func() { FallThroughMode = AnyErrorFallThrough }()
+	if result, err := NewAlternation(missing, name).Execute(source); err != nil || result != "Bob" {
+		t.Errorf("expected Bob but got %v (%v)", result, err)
+	}
+	_, err = NewAlternation(missing, divide, name).Execute(source)
+	if !errors.Is(err, ErrDivisionByZero) || errors.As(err, &ae) {
+		t.Errorf("expected the division by zero to stop the alternation but got %v", err)
+	}
+}
+
+func TestTry_Default(t *testing.T) {
+	missing, _ := NewS("missing")
+	name, _ := NewS("name")
+	source := map[string]interface{}{"name": "Bob", "none": nil}
+
+	if result, err := NewTry(name, &K{"x"}).Execute(source); err != nil || result != "Bob" {
+		t.Errorf("expected Bob but got %v (%v)", result, err)
+	}
+	if result, err := NewTry(missing, name).Execute(source); err != nil || result != "Bob" {
+		t.Errorf("expected the fallback to read the source but got %v (%v)", result, err)
+	}
+	message := &exprSelector{expr: VariableExprAST{Name: "$error"}}
+	result, err := NewTry(missing, message).Execute(source)
+	if msg, _ := result.(string); err != nil || !strings.Contains(msg, "no such key missing") {
+		t.Errorf("expected the error message but got %v (%v)", result, err)
+	}
+
+	none, _ := NewS("none")
+	for _, sel := range []Selector{missing, none} {
+		if result, err := NewDefault(sel, 0).Execute(source); err != nil || result != 0 {
+			t.Errorf("expected 0 but got %v (%v)", result, err)
+		}
+	}
+	deep, _ := NewS("name", "first")
+	if _, err := NewDefault(deep, 0).Execute(source); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected a type mismatch but got %v", err)
+	}
+}
+
+func TestTry_Default_mapping(t *testing.T) {
+	mapping := map[string]interface{}{
+		"ratio":  "TRY(S(\"a\") / S(\"b\"), K(\"n/a\"))",
+		"reason": "try(S(\"a\") / S(\"b\"), \"failed: \" + $error)",
+		"name":   "try(S(\"missing\"), S(\"name\"))",
+		"nick":   "DEFAULT(S(\"nick\"), \"anonymous\")",
+		"count":  "default(S(\"count\"), K(0))",
+	}
+	output, err := Bend(mapping, map[string]interface{}{"a": 1, "b": 0, "name": "Bob"})
 	if err != nil {
-		t.Errorf("Unexpected error: %v", err)
+		t.Fatalf("Unexpected error: %v", err)
 	}
-	if result != expectedResult {
-		t.Errorf("Expected result to be %v but got %v", expectedResult, result)
+	out := output.(map[string]interface{})
+	if out["ratio"] != "n/a" || out["name"] != "Bob" || out["nick"] != "anonymous" || out["count"] != int64(0) {
+		t.Errorf("unexpected output %v", out)
+	}
+	if reason, _ := out["reason"].(string); !strings.HasPrefix(reason, "failed: ") || !strings.Contains(reason, "division by zero") {
+		t.Errorf("expected the error message but got %v", out["reason"])
+	}
+
+	for _, exp := range []string{"TRY(S(\"a\"))", "DEFAULT(S(\"a\"), S(\"b\"))"} {
+		if _, err := Compile(exp); err == nil {
+			t.Errorf("%s: expected a syntax error", exp)
+		}
 	}
 }
 
+func TestControlFlow_lowercase(t *testing.T) {
+	mapping := map[string]interface{}{
+		"kind":  "if(S(\"age\") >= 18, \"adult\", \"minor\")",
+		"first": "al(S(\"nick\"), S(\"name\"))",
+	}
+	output, err := Bend(mapping, map[string]interface{}{"age": 20, "name": "Bob"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"kind": "adult", "first": "Bob"}
+	if !CompareMaps(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+}
 
-**/
+func TestAlternation_mapping(t *testing.T) {
+	source := map[string]interface{}{"n": 1}
+	var ae *AlternationError
+	if _, err := Bend("AL(S(\"n\") / 0, S(\"missing\")) ?? K(1)", source); !errors.As(err, &ae) {
+		t.Errorf("expected `??` to keep the division by zero, but got %v", err)
+	}
+	if r, err := Bend("AL(S(\"a\"), S(\"b\")) ?? K(1)", source); err != nil || r != int64(1) {
+		t.Errorf("expected 1, but got %v (%v)", r, err)
+	}
+	if r, err := Bend("DEFAULT(AL(S(\"a\"), S(\"b\")), 2)", source); err != nil || r != int64(2) {
+		t.Errorf("expected 2, but got %v (%v)", r, err)
+	}
+	for _, exp := range []string{"AL()", "al()"} {
+		if _, err := Compile(exp); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%s: expected a syntax error, but got %v", exp, err)
+		}
+	}
+}
+
+func TestIf_without_else_operand(t *testing.T) {
+	source := map[string]interface{}{"name": "Bob", "admin": false}
+	testCases := []struct {
+		exp    string
+		offset int
+	}{
+		{"IF(S(\"admin\"), K(1)) + 1", 0},
+		{"S(\"name\") + IF(false, K(\"!\"))", 12},
+		{"!IF(S(\"admin\"), K(true))", 1},
+		{"(IF(S(\"admin\"), K(1)) + 1) ?? K(2)", -1},
+	}
+	for _, tc := range testCases {
+		output, err := Bend(map[string]interface{}{"key": tc.exp}, source)
+		if tc.offset < 0 {
+			if err != nil || !CompareMaps(output, map[string]interface{}{"key": int64(2)}) {
+				t.Errorf("%s: expected the `??` fallback, but got %v (%v)", tc.exp, output, err)
+			}
+			continue
+		}
+		var ee *EvalError
+		if !errors.Is(err, ErrNotFound) || errors.Is(err, errOmitted) || !errors.As(err, &ee) || ee.Offset != tc.offset {
+			t.Errorf("%s: expected a not found error at %d, but got %v (%v)", tc.exp, tc.offset, output, err)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package whiteboard
 
 import (
-	"errors"
+	"fmt"
 	"math"
 )
 
@@ -10,14 +10,49 @@ const (
 	AngleMode
 )
 
+// defS is a built-in function, fun evaluates its arguments against context within e itself
+// so that functions such as `noerr` can handle their errors
 type defS struct {
 	argc int
-	fun  func(expr ...ExprAST) float64
+	fun  func(context interface{}, e env, expr ...ExprAST) (float64, error)
 }
 
 // enum "RadianMode", "AngleMode"
 var TrigonometricMode = RadianMode
 
+const (
+	StrictWordMode = iota
+	BareWordMode
+)
+
+// enum "StrictWordMode", "BareWordMode"
+// in BareWordMode an unknown identifier such as `abc` is read as the string "abc",
+// in StrictWordMode it is reported as a syntax error, a path must be written `$.abc`, `a.b` or `a[0]`
+var IdentifierMode = StrictWordMode
+
+const (
+	TruthyConditionMode = iota
+	StrictConditionMode
+)
+
+// enum "TruthyConditionMode", "StrictConditionMode"
+// in TruthyConditionMode the condition of `IF` may be any value: null, false, 0, "" and empty lists
+// and maps are false, everything else is true. In StrictConditionMode it must be a bool.
+// The mode is read when an `IF` is built
+var ConditionMode = TruthyConditionMode
+
+const (
+	AnyErrorFallThrough = iota
+	NotFoundFallThrough
+)
+
+// enum "AnyErrorFallThrough", "NotFoundFallThrough"
+// in AnyErrorFallThrough `AL` tries its next selector whatever made the previous one fail,
+// in NotFoundFallThrough only a missing value (ErrNotFound) does and any other failure,
+// such as a type mismatch or a division by zero, is returned at once.
+// The mode is read when an `AL` is built
+var FallThroughMode = AnyErrorFallThrough
+
 var defConst = map[string]float64{
 	"pi": math.Pi,
 }
@@ -48,111 +83,118 @@ func init() {
 }
 
 // sin(pi/2) = 1
-func defSin(expr ...ExprAST) float64 {
-	return math.Sin(expr2Radian(expr[0]))
+func defSin(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := expr2Radian(expr[0], context, e)
+	return math.Sin(r), err
 }
 
 // cos(0) = 1
-func defCos(expr ...ExprAST) float64 {
-	return math.Cos(expr2Radian(expr[0]))
+func defCos(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := expr2Radian(expr[0], context, e)
+	return math.Cos(r), err
 }
 
 // tan(pi/4) = 1
-func defTan(expr ...ExprAST) float64 {
-	return math.Tan(expr2Radian(expr[0]))
+func defTan(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := expr2Radian(expr[0], context, e)
+	return math.Tan(r), err
 }
 
 // cot(pi/4) = 1
-func defCot(expr ...ExprAST) float64 {
-	return 1 / defTan(expr...)
+func defCot(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := defTan(context, e, expr...)
+	return 1 / r, err
 }
 
 // sec(0) = 1
-func defSec(expr ...ExprAST) float64 {
-	return 1 / defCos(expr...)
+func defSec(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := defCos(context, e, expr...)
+	return 1 / r, err
 }
 
 // csc(pi/2) = 1
-func defCsc(expr ...ExprAST) float64 {
-	return 1 / defSin(expr...)
+func defCsc(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := defSin(context, e, expr...)
+	return 1 / r, err
 }
 
 // abs(-2) = 2
-func defAbs(expr ...ExprAST) float64 {
-	return math.Abs(ExprASTResult(expr[0]).(float64))
+func defAbs(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := exprFloat(expr[0], context, e)
+	return math.Abs(r), err
 }
 
 // ceil(4.2) = ceil(4.8) = 5
-func defCeil(expr ...ExprAST) float64 {
-	return math.Ceil(ExprASTResult(expr[0]).(float64))
+func defCeil(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := exprFloat(expr[0], context, e)
+	return math.Ceil(r), err
 }
 
 // floor(4.2) = floor(4.8) = 4
-func defFloor(expr ...ExprAST) float64 {
-	return math.Floor(ExprASTResult(expr[0]).(float64))
+func defFloor(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := exprFloat(expr[0], context, e)
+	return math.Floor(r), err
 }
 
 // round(4.2) = 4
 // round(4.6) = 5
-func defRound(expr ...ExprAST) float64 {
-	return math.Round(ExprASTResult(expr[0]).(float64))
+func defRound(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := exprFloat(expr[0], context, e)
+	return math.Round(r), err
 }
 
 // sqrt(4) = 2
 // sqrt(4) = abs(sqrt(4))
 // returns only the absolute value of the result
-func defSqrt(expr ...ExprAST) float64 {
-	return math.Sqrt(ExprASTResult(expr[0]).(float64))
+func defSqrt(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := exprFloat(expr[0], context, e)
+	return math.Sqrt(r), err
 }
 
 // cbrt(27) = 3
-func defCbrt(expr ...ExprAST) float64 {
-	return math.Cbrt(ExprASTResult(expr[0]).(float64))
+func defCbrt(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := exprFloat(expr[0], context, e)
+	return math.Cbrt(r), err
 }
 
 // max(2) = 2
 // max(2, 3) = 3
 // max(2, 3, 1) = 3
-func defMax(expr ...ExprAST) float64 {
-	if len(expr) == 0 {
-		panic(errors.New("calling function `max` must have at least one parameter."))
-	}
-	if len(expr) == 1 {
-		return ExprASTResult(expr[0]).(float64)
-	}
-	maxV := ExprASTResult(expr[0]).(float64)
-	for i := 1; i < len(expr); i++ {
-		v := ExprASTResult(expr[i]).(float64)
-		maxV = math.Max(maxV, v)
-	}
-	return maxV
+func defMax(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	return foldFloat("max", math.Max, context, e, expr...)
 }
 
 // min(2) = 2
 // min(2, 3) = 2
 // min(2, 3, 1) = 1
-func defMin(expr ...ExprAST) float64 {
+func defMin(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	return foldFloat("min", math.Min, context, e, expr...)
+}
+
+func foldFloat(name string, fold func(float64, float64) float64, context interface{}, e env, expr ...ExprAST) (float64, error) {
 	if len(expr) == 0 {
-		panic(errors.New("calling function `min` must have at least one parameter."))
+		return 0, fmt.Errorf("calling function `%s` must have at least one parameter.", name)
 	}
-	if len(expr) == 1 {
-		return ExprASTResult(expr[0]).(float64)
+	r, err := exprFloat(expr[0], context, e)
+	if err != nil {
+		return 0, err
 	}
-	maxV := ExprASTResult(expr[0]).(float64)
 	for i := 1; i < len(expr); i++ {
-		v := ExprASTResult(expr[i]).(float64)
-		maxV = math.Min(maxV, v)
+		v, err := exprFloat(expr[i], context, e)
+		if err != nil {
+			return 0, err
+		}
+		r = fold(r, v)
 	}
-	return maxV
+	return r, nil
 }
 
 // noerr(1/0) = 0
 // noerr(2.5/(1-1)) = 0
-func defNoerr(expr ...ExprAST) (r float64) {
-	defer func() {
-		if e := recover(); e != nil {
-			r = 0
-		}
-	}()
-	return ExprASTResult(expr[0]).(float64)
+func defNoerr(context interface{}, e env, expr ...ExprAST) (float64, error) {
+	r, err := exprFloat(expr[0], context, e)
+	if err != nil {
+		return 0, nil
+	}
+	return r, nil
 }
@@ -0,0 +1,123 @@
+package whiteboard
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Failure kinds, test for them with errors.Is, e.g. `errors.Is(err, ErrNotFound)`.
+// The typed errors below carry the details: *PathError the selector path,
+// *EvalError and *SyntaxError the position in the expression
+var (
+	// ErrNotFound is a key, field or match missing from the source
+	ErrNotFound = errors.New("not found")
+	// ErrIndexOutOfRange is an index past the end of a list, it also matches ErrNotFound
+	ErrIndexOutOfRange = errors.New("index out of range")
+	// ErrTypeMismatch is an operand or path element of the wrong type
+	ErrTypeMismatch = errors.New("type mismatch")
+	// ErrDivisionByZero is a `/` or `%` by zero
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrSyntax is an expression that cannot be lexed or parsed
+	ErrSyntax = errors.New("syntax error")
+)
+
+// kindError is a message classified as one of the failure kinds,
+// every classified failure is built with errorOf
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func errorOf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind || (e.kind == ErrIndexOutOfRange && target == ErrNotFound)
+}
+
+// PathError reports a selector path that could not be followed,
+// Path holds the path elements up to and including the failing one
+type PathError struct {
+	Path []interface{}
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("%v, path %v", e.Err, e.Path)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// SyntaxError reports an expression that failed to lex or parse at Offset of Source
+type SyntaxError struct {
+	Source string
+	Offset int
+	Msg    string
+}
+
+func syntaxErrorf(source string, offset int, format string, args ...interface{}) error {
+	return &SyntaxError{Source: source, Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s\n%s", e.Msg, ErrPos(e.Source, e.Offset))
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return ErrSyntax
+}
+
+// recoverError turns a panic into an error, it must be deferred directly
+func recoverError(err *error) {
+	if e := recover(); e != nil {
+		if re, ok := e.(error); ok {
+			*err = re
+		} else {
+			*err = fmt.Errorf("%v", e)
+		}
+	}
+}
+
+// EvalError reports an expression that failed to evaluate,
+// Offset is the position of the failing operator, function or selector in Source
+type EvalError struct {
+	Source string
+	Offset int
+	Err    error
+}
+
+func (e *EvalError) Error() string {
+	if e.Source == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v\n%s", e.Err, ErrPos(e.Source, e.Offset))
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// evalError locates err at offset, an error already located by a deeper node is kept as is
+func evalError(err error, offset int) error {
+	var ee *EvalError
+	if err == nil || errors.As(err, &ee) {
+		return err
+	}
+	return &EvalError{Offset: offset, Err: err}
+}
+
+// withSource fills in the expression source of an EvalError once the caller knows it
+func withSource(err error, source string) error {
+	var ee *EvalError
+	if errors.As(err, &ee) && ee.Source == "" {
+		ee.Source = source
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package whiteboard
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestError_kinds(t *testing.T) {
+	source := map[string]interface{}{
+		"name": "Bob",
+		"age":  20,
+		"pets": []interface{}{map[string]interface{}{"name": "cat"}},
+	}
+	testCases := []struct {
+		exp  string
+		kind error
+	}{
+		{"S(\"owner\", \"name\")", ErrNotFound},
+		{"S(\"pets\", 3, \"name\")", ErrIndexOutOfRange},
+		{"S(\"pets\", 3, \"name\")", ErrNotFound},
+		{"S(\"name\", 0)", ErrTypeMismatch},
+		{"S(\"name\") * 2", ErrTypeMismatch},
+		{"S(\"age\") / 0", ErrDivisionByZero},
+		{"S(\"age\") % 0", ErrDivisionByZero},
+		{"JP(\"$.owner\")", ErrNotFound},
+		{"S(\"age\") +", ErrSyntax},
+		{"S(\"age\"", ErrSyntax},
+		{"\"open", ErrSyntax},
+	}
+
+	for _, tc := range testCases {
+		_, err := Bend(map[string]interface{}{"v": tc.exp}, source)
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("%s: expected %v, but got %v", tc.exp, tc.kind, err)
+		}
+	}
+}
+
+func TestError_details(t *testing.T) {
+	source := map[string]interface{}{"pets": []interface{}{map[string]interface{}{"name": "cat"}}}
+
+	_, err := Bend(map[string]interface{}{"v": "K(1) + S(\"pets\", 0, \"age\")"}, source)
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("expected a *PathError, but got %v", err)
+	}
+	if want := []interface{}{"pets", int64(0), "age"}; !reflect.DeepEqual(pathErr.Path, want) {
+		t.Errorf("expected path %v, but got %v", want, pathErr.Path)
+	}
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Offset != 7 || evalErr.Source != "K(1) + S(\"pets\", 0, \"age\")" {
+		t.Errorf("expected the error located at offset 7, but got %+v", evalErr)
+	}
+
+	_, err = Compile(map[string]interface{}{"a": "1 +* 2"})
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Offset != 3 {
+		t.Errorf("expected a *SyntaxError at offset 3, but got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package whiteboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Trace is one evaluated node of a mapping explained by Explain.
+// Key is the JSON pointer of a mapping entry, or of an element bent by a collection selector,
+// Node describes the AST node or selector with its toStr form, Path is the source path
+// of an S selector and Branch names the branch an IF, a SW, a ternary, an Alternation,
+// a TRY or a DEFAULT took, or "context" for an expression evaluated against the context
+type Trace struct {
+	Key      string
+	Node     string
+	Path     []interface{}
+	Branch   string
+	Value    interface{}
+	Err      error
+	Children []*Trace
+}
+
+// Explain bends source with mapping like Bend and returns how every entry got its value.
+// The nodes are recorded while the mapping is bent once, so every selector runs once as well,
+// and a failing entry does not stop the others from being explained
+func Explain(mapping interface{}, source interface{}, args ...interface{}) (trace *Trace, err error) {
+	defer recoverError(&err)
+	if mapping == nil || source == nil {
+		return nil, errors.New("mapping or source is empty")
+	}
+	context := make(map[interface{}]interface{})
+	for _, arg := range args {
+		if c, ok := arg.(map[interface{}]interface{}); ok {
+			context = c
+		}
+	}
+	bender, err := Compile(mapping)
+	if err != nil {
+		return nil, err
+	}
+	transport := newRootTransport(source, context)
+	transport.options.collectErrors = true
+	holder := &Trace{}
+	transport.trace = holder
+	_bend(bender.mapping, transport, "")
+	return holder.Children[0], nil
+}
+
+// traced records t under the node of e and returns the env recording the steps nested in t
+func (e env) traced(t *Trace) env {
+	e.trace.Children = append(e.trace.Children, t)
+	e.trace = t
+	return e
+}
+
+// setBranch names the branch taken at t, nothing is recorded when not explaining
+func (t *Trace) setBranch(branch string) {
+	if t != nil {
+		t.Branch = branch
+	}
+}
+
+// restart drops the steps recorded at t before evaluating it again for the reason branch
+func (t *Trace) restart(branch string) {
+	if t != nil {
+		t.Children, t.Branch = nil, branch
+	}
+}
+
+// mappingNode describes a part of a compiled mapping
+func mappingNode(mapping interface{}) string {
+	switch m := mapping.(type) {
+	case *compiledList:
+		return "list"
+	case *compiledMap:
+		return "map"
+	case *compiledExpression:
+		return m.exp
+	case *exprSelector:
+		return "expression"
+	case Selector:
+		return selectorName(m)
+	}
+	return "constant"
+}
+
+func selectorName(sel Selector) string {
+	if k, ok := sel.(*K); ok {
+		return fmt.Sprintf("K(%v)", k.Value)
+	}
+	return reflect.Indirect(reflect.ValueOf(sel)).Type().Name()
+}
+
+// String renders the trace as indented text, one node per line
+func (t *Trace) String() string {
+	var sb strings.Builder
+	t.write(&sb, 0)
+	return sb.String()
+}
+
+func (t *Trace) write(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	if t.Key != "" {
+		fmt.Fprintf(sb, "%s: ", t.Key)
+	}
+	sb.WriteString(t.Node)
+	if t.Path != nil {
+		fmt.Fprintf(sb, " path=%v", t.Path)
+	}
+	if t.Branch != "" {
+		fmt.Fprintf(sb, " branch=%s", t.Branch)
+	}
+	if t.Err != nil {
+		// the first line, the rest of an expression error only points at the failing node
+		msg, _, _ := strings.Cut(t.Err.Error(), "\n")
+		fmt.Fprintf(sb, " error=%q", msg)
+	} else if t.Node != "map" && t.Node != "list" {
+		fmt.Fprintf(sb, " = %v", t.Value)
+	}
+	sb.WriteString("\n")
+	for _, child := range t.Children {
+		child.write(sb, depth+1)
+	}
+}
+
+// MarshalJSON renders the trace as nested objects, values are converted like JMES does
+func (t *Trace) MarshalJSON() ([]byte, error) {
+	type trace struct {
+		Key      string        `json:"key,omitempty"`
+		Node     string        `json:"node"`
+		Path     []interface{} `json:"path,omitempty"`
+		Branch   string        `json:"branch,omitempty"`
+		Value    interface{}   `json:"value,omitempty"`
+		Error    string        `json:"error,omitempty"`
+		Children []*Trace      `json:"children,omitempty"`
+	}
+	out := trace{
+		Key:      t.Key,
+		Node:     t.Node,
+		Path:     t.Path,
+		Branch:   t.Branch,
+		Value:    toJSONValue(reflect.ValueOf(t.Value)),
+		Children: t.Children,
+	}
+	if t.Err != nil {
+		out.Error = t.Err.Error()
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,182 @@
+package whiteboard
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	mapping := map[string]interface{}{
+		"kind":  "IF(S(\"age\") >= 18, \"adult\", \"minor\")",
+		"nick":  "S(\"nick\") ?? S(\"name\")",
+		"first": "AL(S(\"x\"), S(\"pets\", 0))",
+		"rank":  []interface{}{"S(\"age\") > 30 ? 1 : 2", 5},
+		"tier":  "SW(S(\"name\"), \"Ann\", K(1), \"Bob\", K(2))",
+	}
+	source := map[string]interface{}{"age": 20, "name": "Bob", "pets": []interface{}{"cat"}}
+
+	trace, err := Explain(mapping, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entries := map[string]*Trace{}
+	var walk func(*Trace)
+	walk = func(tr *Trace) {
+		if tr.Key != "" {
+			entries[tr.Key] = tr
+		}
+		for _, c := range tr.Children {
+			walk(c)
+		}
+	}
+	walk(trace)
+
+	testCases := []struct {
+		key    string
+		value  interface{}
+		branch string
+		tried  int
+	}{
+		{"/kind", "adult", "then", 2},
+		{"/nick", "Bob", "", 2},
+		{"/first", "cat", "1", 2},
+		{"/rank/0", int64(2), "else", 2},
+		{"/rank/1", 5, "", 0},
+		{"/tier", int64(2), "case Bob", 2},
+	}
+	for _, tc := range testCases {
+		tr, ok := entries[tc.key]
+		if !ok {
+			t.Fatalf("%s: missing from the trace", tc.key)
+		}
+		if tr.Value != tc.value {
+			t.Errorf("%s: expected %v, but got %v", tc.key, tc.value, tr.Value)
+		}
+		if tc.tried == 0 {
+			continue
+		}
+		node := tr.Children[0]
+		if node.Branch != tc.branch {
+			t.Errorf("%s: expected branch %q, but got %q", tc.key, tc.branch, node.Branch)
+		}
+		if len(node.Children) != tc.tried {
+			t.Errorf("%s: expected %d evaluated operands, but got %d", tc.key, tc.tried, len(node.Children))
+		}
+	}
+
+	text := trace.String()
+	for _, want := range []string{"/kind: IF(", "branch=then", "path=[age] = 20", "error=\"no such key x, path [x]\""} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in the text trace:\n%s", want, text)
+		}
+	}
+	if _, err := json.Marshal(trace); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestExplain_selectors(t *testing.T) {
+	mapping := map[string]interface{}{
+		"safe":   "TRY(S(\"a\") / S(\"b\"), \"n/a: \" + $error)",
+		"ok":     "try(S(\"a\"), 0)",
+		"nick":   "DEFAULT(S(\"nick\"), \"anonymous\")",
+		"name":   "DEFAULT(S(\"name\"), \"anonymous\")",
+		"other":  "SW(S(\"name\"), \"Ann\", K(1), K(0))",
+		"ages":   "MAP(S(\"pets\"), S(\"age\") + 1)",
+		"labels": "FORALL(S(\"pets\"), $index * 10 + S(\"age\"))",
+		"old":    "COUNT(FILTER(S(\"pets\"), S(\"age\") > 2))",
+	}
+	source := map[string]interface{}{
+		"a": 1, "b": 0, "name": "Bob",
+		"pets": []interface{}{map[string]interface{}{"name": "cat", "age": 2}, map[string]interface{}{"name": "dog", "age": 3}},
+	}
+	trace, err := Explain(mapping, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entries := map[string]*Trace{}
+	for _, tr := range trace.Children {
+		entries[tr.Key] = tr
+	}
+
+	testCases := []struct {
+		key    string
+		branch string
+		// Node of every child of the selector
+		children []string
+	}{
+		{"/safe", "fallback", []string{"BinaryExprAST: (/ SelectorExprAST:S[a] SelectorExprAST:S[b])", "BinaryExprAST: (+ StrExprAST:n/a:  VariableExprAST:$error[])"}},
+		{"/ok", "try", []string{"S"}},
+		{"/nick", "default", []string{"S"}},
+		{"/name", "", []string{"S"}},
+		{"/other", "default", []string{"S", "K(0)"}},
+		{"/ages", "", []string{"S", "item", "item"}},
+		{"/labels", "", []string{"S", "item", "item"}},
+		{"/old", "", []string{"Filter"}},
+	}
+	for _, tc := range testCases {
+		tr, ok := entries[tc.key]
+		if !ok {
+			t.Fatalf("%s: missing from the trace", tc.key)
+		}
+		node := tr.Children[0]
+		if node.Branch != tc.branch {
+			t.Errorf("%s: expected branch %q, but got %q", tc.key, tc.branch, node.Branch)
+		}
+		var children []string
+		for _, c := range node.Children {
+			children = append(children, c.Node)
+		}
+		if !reflect.DeepEqual(children, tc.children) {
+			t.Errorf("%s: expected the children %q, but got %q", tc.key, tc.children, children)
+		}
+	}
+
+	if v := entries["/safe"].Value.(string); !strings.HasPrefix(v, "n/a: ") {
+		t.Errorf("/safe: expected the fallback value, but got %v", v)
+	}
+	item := entries["/labels"].Children[0].Children[2]
+	if item.Key != "/1" || item.Value != int64(13) {
+		t.Errorf("/labels: expected the second element to be explained, but got:\n%s", item)
+	}
+	if text := trace.String(); !strings.Contains(text, "VariableExprAST:$index[] = 1") {
+		t.Errorf("expected the variables in the text trace:\n%s", text)
+	}
+}
+
+func TestExplain_runs_once(t *testing.T) {
+	calls := 0
+	count := NewF(func(v interface{}, args ...interface{}) interface{} {
+		calls++
+		return calls > 0
+	})
+	name, _ := NewS("name")
+	mapping := map[string]interface{}{
+		"kind": NewIf(count, &K{"counted"}, &K{"skipped"}),
+		"name": NewDefault(name, "anonymous"),
+	}
+	trace, err := Explain(mapping, map[string]interface{}{"name": "Bob"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the F selector to run once, but it ran %d times", calls)
+	}
+	kind := trace.Children[0]
+	if kind.Key != "/kind" || kind.Node != "If" || kind.Branch != "then" || kind.Value != "counted" || len(kind.Children) != 2 {
+		t.Errorf("expected the If to be explained, but got:\n%s", kind)
+	}
+}
+
+func TestExplain_short_circuit(t *testing.T) {
+	trace, err := Explain("S(\"age\") > 30 && S(\"missing\") == 1", map[string]interface{}{"age": 20})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	node := trace.Children[0]
+	if node.Value != false || len(node.Children) != 1 {
+		t.Errorf("expected the right operand to be skipped, but got:\n%s", trace)
+	}
+}
@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/ghodss/yaml v1.0.0
+	github.com/jmespath/go-jmespath v0.4.0
 	github.com/sirupsen/logrus v1.9.0
 	github.com/traefik/yaegi v0.15.1
 )
@@ -0,0 +1,421 @@
+package whiteboard
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONPath selects values with a standard JSONPath expression such as
+// `$.pets[?(@.age > 2)].name`, `$.pets[1:3]` or `$['name','id']`.
+// A definite path (one value per segment) returns the value itself,
+// any wildcard, descent, union, slice or filter makes it return a []interface{}.
+// Filters are written in the mapping expression language, `@` is the element being tested
+type JSONPath struct {
+	Path     string
+	segments []jsonPathSegment
+}
+
+type jsonPathSegment struct {
+	// the segment was written after `..`
+	descendant bool
+	// member names, indexes or Wildcard, more than one for a union
+	keys   []interface{}
+	slice  *jsonPathSlice
+	filter ExprAST
+}
+
+type jsonPathSlice struct {
+	start, end *int
+	step       int
+}
+
+func NewJSONPath(path string) (*JSONPath, error) {
+	p := &jsonPathParser{source: path}
+	segments, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &JSONPath{Path: path, segments: segments}, nil
+}
+
+func (j *JSONPath) Execute(source interface{}) (interface{}, error) {
+	if source == nil {
+		return nil, errorOf(ErrNotFound, "KeyError:invalid reflect.Value")
+	}
+	values := []reflect.Value{reflect.ValueOf(source)}
+	for _, seg := range j.segments {
+		values = seg.apply(values)
+	}
+
+	if j.isDefinite() {
+		if len(values) == 0 {
+			return nil, errorOf(ErrNotFound, "no match for %s", j.Path)
+		}
+		return values[0].Interface(), nil
+	}
+	result := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		result = append(result, v.Interface())
+	}
+	return result, nil
+}
+
+func (j *JSONPath) isDefinite() bool {
+	for _, seg := range j.segments {
+		if seg.descendant || seg.slice != nil || seg.filter != nil || len(seg.keys) != 1 || seg.keys[0] == Wildcard {
+			return false
+		}
+	}
+	return true
+}
+
+func (seg jsonPathSegment) apply(values []reflect.Value) []reflect.Value {
+	var next []reflect.Value
+	for _, v := range values {
+		candidates := []reflect.Value{v}
+		if seg.descendant {
+			candidates = descendants(v)
+		}
+		for _, c := range candidates {
+			switch {
+			case seg.filter != nil:
+				for _, child := range children(c) {
+					r, err := ExprASTResultWithContext(seg.filter, child.Interface())
+					if jsonPathTruthy(r, err) {
+						next = append(next, child)
+					}
+				}
+			case seg.slice != nil:
+				next = append(next, seg.slice.apply(c)...)
+			default:
+				for _, key := range seg.keys {
+					next = append(next, jsonPathChild(c, key)...)
+				}
+			}
+		}
+	}
+	return next
+}
+
+// jsonPathChild looks up one member or index, negative indexes count from the end
+func jsonPathChild(v reflect.Value, key interface{}) []reflect.Value {
+	if key == Wildcard {
+		return children(v)
+	}
+	if index, ok := key.(int); ok && index < 0 {
+		elem := indirect(v)
+		if elem.Kind() != reflect.Slice && elem.Kind() != reflect.Array {
+			return nil
+		}
+		key = elem.Len() + index
+	}
+	field, err := (&S{}).findFieldByKind(v, key)
+	if err != nil {
+		return nil
+	}
+	return []reflect.Value{field}
+}
+
+// apply selects elements the way Python slicing does, including negative bounds and steps
+func (s *jsonPathSlice) apply(v reflect.Value) []reflect.Value {
+	v = indirect(v)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil
+	}
+	n := v.Len()
+	bound := func(b *int, def int) int {
+		if b == nil {
+			return def
+		}
+		i := *b
+		if i < 0 {
+			i += n
+		}
+		if s.step > 0 {
+			return clamp(i, 0, n)
+		}
+		return clamp(i, -1, n-1)
+	}
+
+	var result []reflect.Value
+	if s.step > 0 {
+		for i := bound(s.start, 0); i < bound(s.end, n); i += s.step {
+			result = append(result, v.Index(i))
+		}
+	} else {
+		for i := bound(s.start, n-1); i > bound(s.end, -1); i += s.step {
+			result = append(result, v.Index(i))
+		}
+	}
+	return result
+}
+
+func clamp(i, lo, hi int) int {
+	if i < lo {
+		return lo
+	}
+	if i > hi {
+		return hi
+	}
+	return i
+}
+
+// indirect follows pointers and interfaces down to the concrete value
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// jsonPathTruthy decides whether a filter keeps an element: failed lookups drop it,
+// booleans are taken as is and any other present value keeps it, e.g. `[?(@.isbn)]`
+func jsonPathTruthy(v interface{}, err error) bool {
+	if err != nil || v == nil {
+		return false
+	}
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return true
+}
+
+type jsonPathParser struct {
+	source string
+	offset int
+}
+
+func (p *jsonPathParser) parse() ([]jsonPathSegment, error) {
+	s := strings.TrimSpace(p.source)
+	p.source = s
+	if s == "" || (s[0] != '$' && s[0] != '@') {
+		return nil, p.errorf("a JSONPath must start with '$'")
+	}
+	p.offset = 1
+
+	var segments []jsonPathSegment
+	for p.offset < len(p.source) {
+		var seg jsonPathSegment
+		var err error
+		switch {
+		case strings.HasPrefix(p.source[p.offset:], ".."):
+			p.offset += 2
+			if p.offset < len(p.source) && p.source[p.offset] == '[' {
+				seg, err = p.parseBracket()
+			} else {
+				seg, err = p.parseMember()
+			}
+			seg.descendant = true
+		case p.source[p.offset] == '.':
+			p.offset++
+			seg, err = p.parseMember()
+		case p.source[p.offset] == '[':
+			seg, err = p.parseBracket()
+		default:
+			err = p.errorf("want '.' or '['")
+		}
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// parseMember reads `name` or `*` after a dot
+func (p *jsonPathParser) parseMember() (jsonPathSegment, error) {
+	start := p.offset
+	for p.offset < len(p.source) && !strings.ContainsRune(".[ ", rune(p.source[p.offset])) {
+		p.offset++
+	}
+	name := p.source[start:p.offset]
+	switch name {
+	case "":
+		p.offset = start
+		return jsonPathSegment{}, p.errorf("want a member name")
+	case "*":
+		return jsonPathSegment{keys: []interface{}{Wildcard}}, nil
+	}
+	return jsonPathSegment{keys: []interface{}{name}}, nil
+}
+
+// parseBracket reads `[*]`, `['a','b']`, `[0,1]`, `[1:3]` or `[?(...)]`
+func (p *jsonPathParser) parseBracket() (jsonPathSegment, error) {
+	open := p.offset
+	p.offset++
+	p.skipWhitespace()
+	if p.offset >= len(p.source) {
+		p.offset = open
+		return jsonPathSegment{}, p.errorf("unterminated '['")
+	}
+
+	var seg jsonPathSegment
+	var err error
+	switch c := p.source[p.offset]; {
+	case c == '?':
+		seg.filter, err = p.parseFilter()
+		return seg, err
+	case c == '*':
+		p.offset++
+		seg.keys = []interface{}{Wildcard}
+	case c == '\'' || c == '"':
+		seg.keys, err = p.parseUnion(p.parseQuoted)
+	default:
+		if end := strings.IndexByte(p.source[p.offset:], ']'); end >= 0 && strings.Contains(p.source[p.offset:p.offset+end], ":") {
+			seg.slice, err = p.parseSlice()
+		} else {
+			seg.keys, err = p.parseUnion(p.parseIndex)
+		}
+	}
+	if err != nil {
+		return seg, err
+	}
+	return seg, p.expect(']')
+}
+
+func (p *jsonPathParser) parseUnion(item func() (interface{}, error)) ([]interface{}, error) {
+	var keys []interface{}
+	for {
+		key, err := item()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		p.skipWhitespace()
+		if p.offset >= len(p.source) || p.source[p.offset] != ',' {
+			return keys, nil
+		}
+		p.offset++
+		p.skipWhitespace()
+	}
+}
+
+func (p *jsonPathParser) parseQuoted() (interface{}, error) {
+	if p.offset >= len(p.source) || (p.source[p.offset] != '\'' && p.source[p.offset] != '"') {
+		return nil, p.errorf("want a quoted member name")
+	}
+	// reuse the expression lexer for quotes and escapes
+	lexer := &Parser{Source: p.source, offset: p.offset, ch: p.source[p.offset]}
+	tok := lexer.parseConstStr(nil, p.offset)
+	if tok == nil {
+		return nil, lexer.err
+	}
+	p.offset = lexer.offset
+	return tok.Tok, nil
+}
+
+func (p *jsonPathParser) parseIndex() (interface{}, error) {
+	start := p.offset
+	if p.offset < len(p.source) && p.source[p.offset] == '-' {
+		p.offset++
+	}
+	for p.offset < len(p.source) && '0' <= p.source[p.offset] && p.source[p.offset] <= '9' {
+		p.offset++
+	}
+	i, err := strconv.Atoi(p.source[start:p.offset])
+	if err != nil {
+		p.offset = start
+		return nil, p.errorf("want an index")
+	}
+	return i, nil
+}
+
+// parseSlice reads `start:end:step` with every part optional
+func (p *jsonPathParser) parseSlice() (*jsonPathSlice, error) {
+	s := &jsonPathSlice{step: 1}
+	bounds := []**int{&s.start, &s.end}
+	for i := 0; i < 3; i++ {
+		p.skipWhitespace()
+		if p.offset < len(p.source) && p.source[p.offset] != ':' && p.source[p.offset] != ']' {
+			v, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			n := v.(int)
+			if i < 2 {
+				*bounds[i] = &n
+			} else if n == 0 {
+				return nil, p.errorf("slice step cannot be zero")
+			} else {
+				s.step = n
+			}
+		}
+		p.skipWhitespace()
+		if i == 2 || p.offset >= len(p.source) || p.source[p.offset] != ':' {
+			break
+		}
+		p.offset++
+	}
+	return s, nil
+}
+
+// parseFilter reads `?(expr)` or `?expr` up to the closing ']' and parses expr
+// with the mapping expression language
+func (p *jsonPathParser) parseFilter() (ExprAST, error) {
+	p.offset++
+	start := p.offset
+	depth := 0
+	for ; p.offset < len(p.source); p.offset++ {
+		switch c := p.source[p.offset]; c {
+		case '(', '[':
+			depth++
+		case ')':
+			depth--
+		case ']':
+			if depth == 0 {
+				exp := strings.TrimSpace(p.source[start:p.offset])
+				p.offset++
+				return p.parseFilterExpression(exp, start)
+			}
+			depth--
+		case '\'', '"':
+			if _, err := p.parseQuoted(); err != nil {
+				return nil, err
+			}
+			p.offset--
+		}
+	}
+	p.offset = start - 2
+	return nil, p.errorf("unterminated filter")
+}
+
+func (p *jsonPathParser) parseFilterExpression(exp string, start int) (ExprAST, error) {
+	if exp == "" {
+		p.offset = start
+		return nil, p.errorf("empty filter")
+	}
+	toks, err := Parse(exp)
+	if err != nil {
+		return nil, fmt.Errorf("bad filter in JSONPath %s: %w", p.source, err)
+	}
+	ast := NewAST(toks, exp)
+	if ast.Err != nil {
+		return nil, fmt.Errorf("bad filter in JSONPath %s: %w", p.source, ast.Err)
+	}
+	filter := ast.ParseExpression()
+	if ast.Err != nil {
+		return nil, fmt.Errorf("bad filter in JSONPath %s: %w", p.source, ast.Err)
+	}
+	return filter, nil
+}
+
+func (p *jsonPathParser) expect(c byte) error {
+	p.skipWhitespace()
+	if p.offset >= len(p.source) || p.source[p.offset] != c {
+		return p.errorf(fmt.Sprintf("want '%c'", c))
+	}
+	p.offset++
+	return nil
+}
+
+func (p *jsonPathParser) skipWhitespace() {
+	for p.offset < len(p.source) && p.source[p.offset] == ' ' {
+		p.offset++
+	}
+}
+
+func (p *jsonPathParser) errorf(msg string) error {
+	return syntaxErrorf(p.source, p.offset, "bad JSONPath, %s", msg)
+}
@@ -0,0 +1,83 @@
+package whiteboard
+
+import (
+	"reflect"
+	"testing"
+)
+
+var jsonPathSource = map[string]interface{}{
+	"name": "Bob",
+	"id":   123,
+	"pets": []interface{}{
+		map[string]interface{}{"name": "cat", "age": 2},
+		map[string]interface{}{"name": "dog", "age": 3},
+		map[interface{}]interface{}{"name": "fish", "age": 5, "tags": []string{"wet"}},
+	},
+}
+
+func TestJSONPath_Execute(t *testing.T) {
+	testCases := []struct {
+		path string
+		want interface{}
+	}{
+		{"$", jsonPathSource},
+		{"$.name", "Bob"},
+		{"$.pets[0].name", "cat"},
+		{"$['pets'][-1]['name']", "fish"},
+		{"$.pets[*].name", []interface{}{"cat", "dog", "fish"}},
+		{"$.pets[?(@.age > 2)].name", []interface{}{"dog", "fish"}},
+		{"$.pets[?(@.age >= 2 && @.name != 'dog')].name", []interface{}{"cat", "fish"}},
+		{"$.pets[?(@.tags)].name", []interface{}{"fish"}},
+		{"$.pets[?(@.name == \"a]b\")]", []interface{}{}},
+		{"$.pets[1:3].name", []interface{}{"dog", "fish"}},
+		{"$.pets[:1].name", []interface{}{"cat"}},
+		{"$.pets[::-1].age", []interface{}{5, 3, 2}},
+		{"$.pets[0,2].name", []interface{}{"cat", "fish"}},
+		{"$['name','id']", []interface{}{"Bob", 123}},
+		{"$..name", []interface{}{"Bob", "cat", "dog", "fish"}},
+		{"$..tags[0]", []interface{}{"wet"}},
+	}
+
+	for _, tc := range testCases {
+		jp, err := NewJSONPath(tc.path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.path, err)
+		}
+		got, err := jp.Execute(jsonPathSource)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.path, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, but got %v", tc.path, tc.want, got)
+		}
+	}
+}
+
+func TestJSONPath_Execute_not_found(t *testing.T) {
+	jp, _ := NewJSONPath("$.owner.name")
+	if _, err := jp.Execute(jsonPathSource); err == nil {
+		t.Errorf("Expected an error for a missing definite path")
+	}
+}
+
+func TestJSONPath_syntax_error(t *testing.T) {
+	for _, path := range []string{"pets", "$.", "$.pets[", "$.pets[?(@.age >)]", "$.pets[::0]", "$x"} {
+		if _, err := NewJSONPath(path); err == nil {
+			t.Errorf("%s: expected a syntax error", path)
+		}
+	}
+}
+
+func TestBend_with_JSONPath(t *testing.T) {
+	mapping := map[string]interface{}{
+		"old": "JP(\"$.pets[?(@.age>2)].name\")",
+	}
+	output, err := Bend(mapping, jsonPathSource)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	expect := map[string]interface{}{"old": []interface{}{"dog", "fish"}}
+	if !CompareMaps(output, expect) {
+		t.Errorf("expected output %v, but got %v", expect, output)
+	}
+}
@@ -0,0 +1,37 @@
+package whiteboard
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Logger receives the diagnostics of lexing, parsing and bending.
+// The package is silent until one is set with SetLogger
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+var logger Logger = nopLogger{}
+
+// SetLogger routes the diagnostics of the package to l, nil silences them again.
+// It is meant to be called once at start up, before any mapping is compiled
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger = l
+}
+
+// NewLogrusLogger adapts a logrus logger or entry, its level decides what is written
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return l.WithField("module", "whiteboard")
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
@@ -0,0 +1,54 @@
+package whiteboard
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+}
+func (r *recordingLogger) Infof(format string, args ...interface{})  {}
+func (r *recordingLogger) Warnf(format string, args ...interface{})  {}
+func (r *recordingLogger) Errorf(format string, args ...interface{}) {}
+
+func TestSetLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	SetLogger(rec)
+	defer SetLogger(nil)
+
+	if _, err := Bend(map[string]interface{}{"name": "S(\"name\")"}, map[string]interface{}{"name": "Bob"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(strings.Join(rec.lines, "\n"), "/name S(\"name\") = Bob") {
+		t.Errorf("expected the evaluation to be logged, but got %v", rec.lines)
+	}
+}
+
+func TestNewLogrusLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.SetOutput(&buf)
+	l.SetLevel(logrus.InfoLevel)
+	SetLogger(NewLogrusLogger(l))
+	defer SetLogger(nil)
+
+	Bend(map[string]interface{}{"name": "S(\"name\")"}, map[string]interface{}{"name": "Bob"})
+	if buf.Len() != 0 {
+		t.Errorf("expected debug output to be filtered by the logrus level, but got %s", buf.String())
+	}
+
+	l.SetLevel(logrus.DebugLevel)
+	Bend(map[string]interface{}{"name": "S(\"name\")"}, map[string]interface{}{"name": "Bob"})
+	if !strings.Contains(buf.String(), "module=whiteboard") {
+		t.Errorf("expected debug output through logrus, but got %s", buf.String())
+	}
+}
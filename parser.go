@@ -2,6 +2,7 @@ package whiteboard
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 )
 
@@ -15,6 +16,12 @@ const (
 	COMMA
 	// Function
 	FUCTION
+	// e.g. "abc"
+	STRING
+	// e.g. $.a[0].b
+	PATH
+	// e.g. $item.name
+	VARIABLE
 )
 
 type Token struct {
@@ -25,6 +32,8 @@ type Token struct {
 	Flag int
 
 	Offset int
+	// keys of a PATH token, a VARIABLE token has its name first
+	Path []interface{}
 }
 
 type Parser struct {
@@ -40,7 +49,9 @@ func Parse(s string) ([]*Token, error) {
 	p := &Parser{
 		Source: s,
 		err:    nil,
-		ch:     s[0],
+	}
+	if len(s) > 0 {
+		p.ch = s[0]
 	}
 	toks := p.parse()
 	if p.err != nil {
@@ -70,9 +81,21 @@ func (p *Parser) nextTok() *Token {
 	for p.isWhitespace(p.ch) && err == nil {
 		err = p.nextCh()
 	}
+	if err != nil {
+		return nil
+	}
 	start := p.offset
 	var tok *Token
 	switch p.ch {
+	case
+		'=',
+		'!',
+		'<',
+		'>',
+		'&',
+		'|',
+		'?':
+		tok = p.parseLogicalOperator(start)
 	case
 		'(',
 		')',
@@ -81,7 +104,8 @@ func (p *Parser) nextTok() *Token {
 		'*',
 		'/',
 		'^',
-		'%':
+		'%',
+		':':
 		tok = &Token{
 			Tok:  string(p.ch),
 			Type: Operator,
@@ -118,8 +142,23 @@ func (p *Parser) nextTok() *Token {
 		}
 		tok.Offset = start
 		err = p.nextCh()
-	case '"':
+	case '"', '\'':
 		tok = p.parseConstStr(tok, start)
+	case '$', '@':
+		// both name the current value, `@` reads naturally inside JSONPath filters
+		sigil := p.ch
+		p.nextCh()
+		path := []interface{}{}
+		if sigil == '$' && p.offset < len(p.Source) && p.isChar(p.ch) {
+			// `$item` is a variable such as the ones ForAll binds
+			for p.isWordChar(p.ch) && p.nextCh() == nil {
+			}
+			path = append(path, p.Source[start:p.offset])
+		}
+		tok = p.parsePath(start, path)
+		if tok != nil && len(path) > 0 {
+			tok.Type = VARIABLE
+		}
 	default:
 		tok = p.parseCustomFuc(tok, start)
 	}
@@ -127,48 +166,215 @@ func (p *Parser) nextTok() *Token {
 	return tok
 }
 
+// parseLogicalOperator scans the comparison, boolean and conditional operators:
+// == != < <= > >= && || ! ? ??
+func (p *Parser) parseLogicalOperator(start int) *Token {
+	first := p.ch
+	op := string(first)
+	if p.nextCh() == nil {
+		switch {
+		case p.ch == '=' && (first == '=' || first == '!' || first == '<' || first == '>'),
+			p.ch == '&' && first == '&',
+			p.ch == '|' && first == '|',
+			p.ch == '?' && first == '?':
+			op += string(p.ch)
+			p.nextCh()
+		}
+	}
+	if op == "=" || op == "&" || op == "|" {
+		p.err = syntaxErrorf(p.Source, start,
+			"unsupported operator '%s'",
+			op)
+		return nil
+	}
+	return &Token{
+		Tok:    op,
+		Type:   Operator,
+		Offset: start,
+	}
+}
+
 func (p *Parser) parseCustomFuc(tok *Token, start int) *Token {
 	// fmt.Println("parseCustomFuc")
-	if p.isSelectorWord() || p.isControlFlowWord() {
-		for p.ch != '(' && p.nextCh() == nil {
+	for p.isWordChar(p.ch) && p.nextCh() == nil {
+	}
+	if p.offset == start {
+		p.err = syntaxErrorf(p.Source, start,
+			"unexpected character '%c'",
+			p.ch)
+		return nil
+	}
+	word := p.Source[start:p.offset]
+	tok = &Token{
+		Tok:    word,
+		Type:   Identifier,
+		Offset: start,
+	}
+	// a selector or control flow name is only a function when it is called
+	if (p.isSelectorWord(word) || p.isControlFlowWord(word)) && p.isCallAhead() {
+		tok.Type = FUCTION
+	} else if p.offset < len(p.Source) && (p.ch == '.' || p.ch == '[') {
+		return p.parsePath(start, []interface{}{word})
+	}
 
-		}
-		if p.ch == '(' {
-			tok = &Token{
-				Tok:    p.Source[start:p.offset],
-				Type:   FUCTION,
-				Offset: start,
+	return tok
+}
+
+// parsePath scans the rest of a path such as `$.a[0].b`, `a.b.c` or `a["key with space"]`
+// into the keys of an S selector, integer segments become slice indexes.
+// `*` and `[*]` are read as Wildcard and `..` as RecursiveDescent
+func (p *Parser) parsePath(start int, path []interface{}) *Token {
+	for p.offset < len(p.Source) && (p.ch == '.' || p.ch == '[') {
+		if p.ch == '.' && p.peek() == '.' {
+			path = append(path, RecursiveDescent)
+			p.nextCh()
+			if p.peek() == '[' {
+				p.nextCh()
 			}
 		}
-		return tok
+		var key interface{}
+		if p.ch == '.' {
+			key = p.parseDotSegment()
+		} else {
+			key = p.parseBracketSegment()
+		}
+		if p.err != nil {
+			return nil
+		}
+		path = append(path, key)
+	}
+	return &Token{
+		Tok:    p.Source[start:p.offset],
+		Type:   PATH,
+		Offset: start,
+		Path:   path,
 	}
+}
 
+func (p *Parser) parseDotSegment() interface{} {
+	if p.nextCh() == nil && p.ch == '*' {
+		p.nextCh()
+		return Wildcard
+	}
+	if p.offset >= len(p.Source) || !p.isWordChar(p.ch) {
+		p.err = syntaxErrorf(p.Source, p.offset,
+			"want a key after '.'")
+		return nil
+	}
+	start := p.offset
 	for p.isWordChar(p.ch) && p.nextCh() == nil {
 	}
-	tok = &Token{
-		Tok:  p.Source[start:p.offset],
-		Type: Identifier,
+	key := p.Source[start:p.offset]
+	if i, err := strconv.Atoi(key); err == nil {
+		return i
 	}
-	tok.Offset = start
-
-	return tok
+	return key
 }
 
-func (p *Parser) parseConstStr(tok *Token, start int) *Token {
+func (p *Parser) parseBracketSegment() interface{} {
+	open := p.offset
 	p.nextCh()
-	for p.ch != '"' && p.nextCh() == nil {
+	p.skipWhitespace()
+	var key interface{}
+	switch {
+	case p.offset >= len(p.Source):
+	case p.ch == '*':
+		key = Wildcard
+		p.nextCh()
+	case p.ch == '"' || p.ch == '\'':
+		tok := p.parseConstStr(nil, p.offset)
+		if tok == nil {
+			return nil
+		}
+		key = tok.Tok
+	case '0' <= p.ch && p.ch <= '9':
+		start := p.offset
+		for '0' <= p.ch && p.ch <= '9' && p.nextCh() == nil {
+		}
+		key, _ = strconv.Atoi(p.Source[start:p.offset])
 	}
+	p.skipWhitespace()
+	if key == nil || p.offset >= len(p.Source) || p.ch != ']' {
+		p.err = syntaxErrorf(p.Source, open,
+			"want a quoted key or an index between '[' and ']'")
+		return nil
+	}
+	p.nextCh()
+	return key
+}
 
-	if p.ch == '"' {
-		tok = &Token{
-			Tok:  p.Source[start+1 : p.offset],
-			Type: Identifier,
+// peek returns the character after the current one, or 0 at the end of the source
+func (p *Parser) peek() byte {
+	if p.offset+1 < len(p.Source) {
+		return p.Source[p.offset+1]
+	}
+	return 0
+}
+
+func (p *Parser) skipWhitespace() {
+	for p.offset < len(p.Source) && p.isWhitespace(p.ch) && p.nextCh() == nil {
+	}
+}
+
+// isCallAhead reports whether the next non-blank character is '('
+func (p *Parser) isCallAhead() bool {
+	rest := strings.TrimLeft(p.Source[p.offset:], " \t\n\v\f\r")
+	return strings.HasPrefix(rest, "(")
+}
+
+// parseConstStr scans a string literal enclosed in double or single quotes,
+// Go style escape sequences such as \n, \t, \", \' and \uXXXX are decoded into the token
+func (p *Parser) parseConstStr(tok *Token, start int) *Token {
+	quote := p.ch
+	var sb strings.Builder
+	for p.nextCh() == nil {
+		switch p.ch {
+		case quote:
+			tok = &Token{
+				Tok:    sb.String(),
+				Type:   STRING,
+				Offset: start + 1,
+			}
+			p.nextCh()
+			return tok
+		case '\\':
+			if !p.parseEscape(&sb) {
+				return nil
+			}
+		default:
+			sb.WriteByte(p.ch)
 		}
-		tok.Offset = start + 1
-		p.nextCh()
 	}
 
-	return tok
+	p.err = syntaxErrorf(p.Source, start,
+		"unterminated string literal")
+	return nil
+}
+
+// parseEscape decodes the escape sequence at the current offset and leaves
+// the parser on its last character
+func (p *Parser) parseEscape(sb *strings.Builder) bool {
+	s := p.Source[p.offset:]
+	// both quotes may be escaped whichever one delimits the literal
+	if len(s) > 1 && (s[1] == '"' || s[1] == '\'') {
+		sb.WriteByte(s[1])
+		p.nextCh()
+		return true
+	}
+	value, multibyte, tail, err := strconv.UnquoteChar(s, 0)
+	if err != nil {
+		p.err = syntaxErrorf(p.Source, p.offset,
+			"invalid escape sequence in string literal")
+		return false
+	}
+	if multibyte {
+		sb.WriteRune(value)
+	} else {
+		sb.WriteByte(byte(value))
+	}
+	p.offset += len(s) - len(tail) - 1
+	p.ch = p.Source[p.offset]
+	return true
 }
 
 func (p *Parser) nextCh() error {
@@ -198,35 +404,57 @@ func (p *Parser) isChar(c byte) bool {
 }
 
 func (p *Parser) isWordChar(c byte) bool {
-	return p.isChar(c) || '0' <= c && c <= '9'
+	return p.isChar(c) || '0' <= c && c <= '9' || c == '_'
 }
 
-var selectorWord string = "kKsSfFExpS"
-
-func (p *Parser) isSelectorWord() bool {
-	// fmt.Printf("isSelectorWord: %d %d %d\n", strings.IndexByte(selectorWord, p.ch), strings.Index(p.Source[p.offset:], "("), strings.Index(p.Source[p.offset:], ")"))
-
-	return (strings.IndexByte(selectorWord, p.ch) != -1 ||
-		(p.offset+4 <= len(p.Source) && strings.Contains(selectorWord, p.Source[p.offset:p.offset+4]))) &&
-		strings.Index(p.Source[p.offset:], "(") >= 1 &&
-		strings.Index(p.Source[p.offset:], ")") >= 2
+var defSelectorFuc = map[string]bool{
+	"K":    true,
+	"S":    true,
+	"F":    true,
+	"ExpS": true,
+	"JP":   true,
+	"JMES": true,
 }
 
-var controlFlowWord string = "IFifAltaltSwtswt"
+func (p *Parser) isSelectorWord(word string) bool {
+	return defSelectorFuc[word]
+}
 
 var defConstFuc = map[string]bool{
-	"IF": true,
-	"if": true,
-	"SW": true,
-	"sw": true,
-	"AL": true,
-	"al": true,
+	"IF":      true,
+	"if":      true,
+	"SW":      true,
+	"sw":      true,
+	"AL":      true,
+	"al":      true,
+	"TRY":     true,
+	"try":     true,
+	"DEFAULT": true,
+	"default": true,
+	"MAP":     true,
+	"map":     true,
+	"FILTER":  true,
+	"filter":  true,
+	"REDUCE":  true,
+	"reduce":  true,
+	"FORALL":  true,
+	"forall":  true,
+	"SORTBY":  true,
+	"sortby":  true,
+	"GROUPBY": true,
+	"groupby": true,
+	"UNIQUE":  true,
+	"unique":  true,
+	"FLATTEN": true,
+	"flatten": true,
+	"ZIP":     true,
+	"zip":     true,
+	"SLICE":   true,
+	"slice":   true,
+	"COUNT":   true,
+	"count":   true,
 }
 
-func (p *Parser) isControlFlowWord() bool {
-	// fmt.Printf("isControlFlowWord: %c %d %d\n", p.ch, strings.Index(p.Source[p.offset:], "("), strings.LastIndex(p.Source[p.offset:], ")"))
-
-	return p.offset+2 <= len(p.Source) && defConstFuc[p.Source[p.offset:p.offset+2]] &&
-		strings.Index(p.Source[p.offset:], "(") < strings.LastIndex(p.Source[p.offset:], ")")
-
+func (p *Parser) isControlFlowWord(word string) bool {
+	return defConstFuc[word]
 }
@@ -0,0 +1,72 @@
+package whiteboard
+
+// scope holds the variables an expression reads as `$name`, such as `$root` or the `$item` of a ForAll.
+// A scope sees the variables of its parents unless it binds the same name again
+type scope struct {
+	parent *scope
+	vars   map[string]interface{}
+}
+
+// with returns a child scope binding vars on top of s, s may be nil
+func (s *scope) with(vars map[string]interface{}) *scope {
+	return &scope{parent: s, vars: vars}
+}
+
+// lookup finds the innermost binding of name
+func (s *scope) lookup(name string) (interface{}, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// env is what an evaluation carries along besides its source
+type env struct {
+	scope *scope
+	// transport of the mapping being bent, nil when a selector is executed on its own
+	transport *Transport
+	// node the evaluation steps are recorded under by Explain, nil when not explaining
+	trace *Trace
+}
+
+// with returns e with a child scope binding vars
+func (e env) with(vars map[string]interface{}) env {
+	e.scope = e.scope.with(vars)
+	return e
+}
+
+// rootEnv is the env of a top-level evaluation, `$root` is its source
+func rootEnv(source interface{}) env {
+	return env{scope: (*scope)(nil).with(map[string]interface{}{"$root": source})}
+}
+
+// scopedSelector is a Selector running nested selectors or expressions,
+// which must see the variables of the env it is executed in
+type scopedSelector interface {
+	executeScoped(source interface{}, e env) (interface{}, error)
+}
+
+// execute runs sel against source within e, a plain Selector only sees its source.
+// When e is traced sel is recorded as a node, an expression records its own nodes
+func execute(sel Selector, source interface{}, e env) (interface{}, error) {
+	if _, ok := sel.(*exprSelector); ok || e.trace == nil {
+		return executeNode(sel, source, e)
+	}
+	t := &Trace{Node: selectorName(sel)}
+	if s, ok := sel.(*S); ok {
+		t.Path = s.Path
+	}
+	r, err := executeNode(sel, source, e.traced(t))
+	t.Value, t.Err = r, err
+	return r, err
+}
+
+// executeNode is execute without a node of its own, for a selector the caller already recorded
+func executeNode(sel Selector, source interface{}, e env) (interface{}, error) {
+	if s, ok := sel.(scopedSelector); ok {
+		return s.executeScoped(source, e)
+	}
+	return sel.Execute(source)
+}
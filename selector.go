@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
 )
 
 type Selector interface {
@@ -26,6 +29,16 @@ type S struct {
 	Path []interface{}
 }
 
+// PathToken is a segment of an S path that does not name a concrete key
+type PathToken string
+
+const (
+	// Wildcard fans out over every element of a slice, array, map or struct
+	Wildcard PathToken = "*"
+	// RecursiveDescent applies the following segment at any depth, e.g. `$..name`
+	RecursiveDescent PathToken = ".."
+)
+
 func NewS(path ...interface{}) (*S, error) {
 	if len(path) == 0 {
 		return nil, errors.New("No path given")
@@ -35,14 +48,17 @@ func NewS(path ...interface{}) (*S, error) {
 
 func (s *S) Execute(source interface{}) (interface{}, error) {
 	if source == nil {
-		return nil, fmt.Errorf("KeyError:invalid reflect.Value")
+		return nil, &PathError{Err: errorOf(ErrNotFound, "KeyError:invalid reflect.Value")}
 	}
 	v := reflect.ValueOf(source)
 
-	for _, key := range s.Path {
+	for i, key := range s.Path {
+		if _, ok := key.(PathToken); ok {
+			return s.executeAll(v, s.Path[i:]), nil
+		}
 		field, err := s.findFieldByKind(v, key)
 		if err != nil {
-			return nil, err
+			return nil, &PathError{Path: s.Path[:i+1], Err: err}
 		}
 		v = field
 	}
@@ -50,42 +66,111 @@ func (s *S) Execute(source interface{}) (interface{}, error) {
 	return v.Interface(), nil
 }
 
+// executeAll follows a path containing Wildcard or RecursiveDescent segments and collects every match.
+// Like JSONPath, values missing a concrete key are skipped instead of failing the whole selection
+func (s *S) executeAll(v reflect.Value, path []interface{}) []interface{} {
+	values := []reflect.Value{v}
+	for _, key := range path {
+		var next []reflect.Value
+		for _, val := range values {
+			switch key {
+			case Wildcard:
+				next = append(next, children(val)...)
+			case RecursiveDescent:
+				next = append(next, descendants(val)...)
+			default:
+				if field, err := s.findFieldByKind(val, key); err == nil {
+					next = append(next, field)
+				}
+			}
+		}
+		values = next
+	}
+
+	result := make([]interface{}, 0, len(values))
+	for _, val := range values {
+		result = append(result, val.Interface())
+	}
+	return result
+}
+
+// children lists the elements of a slice or array, the values of a map in key order
+// and the exported fields of a struct
+func children(v reflect.Value) []reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	var result []reflect.Value
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			result = append(result, v.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range sortedMapKeys(v) {
+			result = append(result, v.MapIndex(key))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				result = append(result, v.Field(i))
+			}
+		}
+	}
+	return result
+}
+
+// descendants lists v itself followed by everything below it, depth first
+func descendants(v reflect.Value) []reflect.Value {
+	result := []reflect.Value{v}
+	for _, child := range children(v) {
+		result = append(result, descendants(child)...)
+	}
+	return result
+}
+
 func (s *S) findFieldByKind(v reflect.Value, key interface{}) (reflect.Value, error) {
 
 	k := reflect.ValueOf(key)
+	if !k.IsValid() {
+		return reflect.Value{}, errorOf(ErrTypeMismatch, "path element cannot be null")
+	}
 	// fmt.Printf("%v -- > %v\n", key, v)
-	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+	// follow pointer-to-struct chains and interfaces holding pointers
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
 		if v.IsNil() {
-			return reflect.Value{}, fmt.Errorf("nil encountered in path")
+			return reflect.Value{}, errorOf(ErrNotFound, "nil encountered in path")
 		}
 		v = v.Elem()
 	}
 
 	if !IsValidMatch(v, k) {
-		return reflect.Value{}, fmt.Errorf("type inconsistency %s- > %s", k.Kind(), v.Kind())
+		switch {
+		case v.Kind() == reflect.Map:
+			return reflect.Value{}, errorOf(ErrNotFound, "no such key %v", key)
+		case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && k.CanInt():
+			return reflect.Value{}, errorOf(ErrIndexOutOfRange, "index out of range: %v", key)
+		}
+		return reflect.Value{}, errorOf(ErrTypeMismatch, "type inconsistency %s- > %s", k.Kind(), v.Kind())
 	}
 
 	switch v.Kind() {
 	case reflect.Struct:
-		field := v.FieldByName(key.(string))
-		if !field.IsValid() {
-			return reflect.Value{}, fmt.Errorf("no such field %s", key)
+		field, err := structField(v, k.String())
+		if err != nil {
+			return reflect.Value{}, err
 		}
 		v = field
-		// TODO: A field is a structure whose internal fields are recursively accessed
-		// if v.Type().Kind() == reflect.Struct {
-		// 	var err error
-		// 	v, err = s.findFieldByKind(v, key)
-		// 	if err != nil {
-		// 		return reflect.Value{}, err
-		// 	}
-		// }
 	case reflect.Slice, reflect.Array:
 		// TODO:	interface {} is string, not int
 		// index := key.(int)
 		index := int(k.Int())
 		if index < 0 || index >= v.Len() {
-			return reflect.Value{}, fmt.Errorf("index out of range: %d", index)
+			return reflect.Value{}, errorOf(ErrIndexOutOfRange, "index out of range: %d", index)
 		}
 		v = v.Index(index)
 
@@ -95,17 +180,110 @@ func (s *S) findFieldByKind(v reflect.Value, key interface{}) (reflect.Value, er
 		// fmt.Print(key.Kind())
 		elem := v.MapIndex(k)
 		if !elem.IsValid() {
-			return reflect.Value{}, fmt.Errorf("no such key %s", key)
+			return reflect.Value{}, errorOf(ErrNotFound, "no such key %s", key)
 		}
 		v = elem
 	default:
-		return reflect.Value{}, fmt.Errorf("cannot access path element %s of non-composite type %s", key, v.Kind())
+		return reflect.Value{}, errorOf(ErrTypeMismatch, "cannot access path element %s of non-composite type %s", key, v.Kind())
 	}
 
 	// fmt.Printf("findFieldByKind-->%v\n", v)
 	return v, nil
 }
 
+// JMES selects values with a JMESPath expression such as `pets[?age > `2`].name | sort(@)`.
+// The source is converted to plain JSON values first, so numbers come back as float64
+type JMES struct {
+	Expression string
+	query      *jmespath.JMESPath
+}
+
+func NewJMES(expression string) (*JMES, error) {
+	query, err := jmespath.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &JMES{Expression: expression, query: query}, nil
+}
+
+func (j *JMES) Execute(source interface{}) (interface{}, error) {
+	return j.query.Search(toJSONValue(reflect.ValueOf(source)))
+}
+
+// structField looks a struct field up by its `json` or `yaml` tag name or by its Go name,
+// fields promoted from embedded structs are found as well, see findStructField
+func structField(v reflect.Value, name string) (reflect.Value, error) {
+	index, ok := findStructField(v.Type(), name)
+	if !ok {
+		return reflect.Value{}, errorOf(ErrNotFound, "no such field %s", name)
+	}
+	field, err := v.FieldByIndexErr(index)
+	if err != nil {
+		// promoted through a nil embedded pointer
+		return reflect.Value{}, errorOf(ErrNotFound, "nil encountered in path to field %s", name)
+	}
+	if !field.CanInterface() {
+		return reflect.Value{}, errorOf(ErrTypeMismatch, "field %s of %s is unexported", name, v.Type())
+	}
+	return field, nil
+}
+
+// findStructField resolves name like Go resolves a promoted field: embedded structs are searched
+// depth by depth, the shallowest field wins and two fields at the same depth hide each other
+// as they do for encoding/json
+func findStructField(t reflect.Type, name string) ([]int, bool) {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	level := []embedded{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for len(level) > 0 {
+		var next []embedded
+		var found []int
+		matches := 0
+		for _, e := range level {
+			if visited[e.typ] {
+				continue
+			}
+			for i := 0; i < e.typ.NumField(); i++ {
+				f := e.typ.Field(i)
+				index := append(append([]int{}, e.index...), i)
+				if fieldKey(f) == name || f.Name == name {
+					found = index
+					matches++
+					continue
+				}
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if f.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{typ: ft, index: index})
+				}
+			}
+		}
+		if matches > 0 {
+			return found, matches == 1
+		}
+		for _, e := range level {
+			visited[e.typ] = true
+		}
+		level = next
+	}
+	return nil, false
+}
+
+// fieldKey is the name a struct field is addressed by: its `json` tag, its `yaml` tag or its Go name
+func fieldKey(f reflect.StructField) string {
+	for _, tag := range []string{"json", "yaml"} {
+		if name, _, _ := strings.Cut(f.Tag.Get(tag), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
 type F struct {
 	Func func(interface{}, ...interface{}) interface{}
 	Args []interface{}
@@ -116,7 +294,9 @@ func NewF(f func(interface{}, ...interface{}) interface{}, args ...interface{})
 	return &F{Func: f, Args: args}
 }
 
-func (f *F) Execute(value interface{}) (interface{}, error) {
+func (f *F) Execute(value interface{}) (result interface{}, err error) {
+	// a panicking function fails the selection instead of the caller
+	defer recoverError(&err)
 	args := f.Args
 	if len(args) == 0 { // add this line to check if args is empty
 		args = make([]interface{}, 1)
@@ -135,11 +315,15 @@ type ExpressionSelector struct {
 }
 
 func (e *ExpressionSelector) Execute(val interface{}) (interface{}, error) {
-	leftVal, err := e.left.Execute(val)
+	return e.executeScoped(val, rootEnv(val))
+}
+
+func (e *ExpressionSelector) executeScoped(val interface{}, env env) (interface{}, error) {
+	leftVal, err := execute(e.left, val, env)
 	if err != nil {
 		return nil, err
 	}
-	rightVal, err := e.right.Execute(val)
+	rightVal, err := execute(e.right, val, env)
 	if err != nil {
 		return nil, err
 	}
@@ -156,3 +340,18 @@ func (e *ExpressionSelector) Execute(val interface{}) (interface{}, error) {
 func NewExpressionSelector(left, right Selector, operator string) *ExpressionSelector {
 	return &ExpressionSelector{left: left, right: right, operator: operator}
 }
+
+// exprSelector adapts an expression node to the Selector interface so that
+// composite arguments, e.g. `IF(S("age") >= 18, ...)`, can be handed to
+// selectors that only understand Selector values.
+type exprSelector struct {
+	expr ExprAST
+}
+
+func (e *exprSelector) Execute(source interface{}) (interface{}, error) {
+	return ExprASTResultWithContext(e.expr, source)
+}
+
+func (e *exprSelector) executeScoped(source interface{}, env env) (interface{}, error) {
+	return evalExpr(e.expr, source, env)
+}
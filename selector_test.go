@@ -1,6 +1,7 @@
 package whiteboard
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
@@ -193,3 +194,229 @@ func TestF_Execute_with_args(t *testing.T) {
 	}
 
 }
+
+func TestS_Execute_wildcard(t *testing.T) {
+	source := map[string]interface{}{
+		"name": "Bob",
+		"pets": []interface{}{
+			map[string]interface{}{"name": "cat", "age": 2},
+			map[string]interface{}{"name": "dog", "age": 3},
+			map[string]interface{}{"age": 1},
+		},
+	}
+
+	s, _ := NewS("pets", Wildcard, "name")
+	result, err := s.Execute(source)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %v", err)
+	}
+	expected := []interface{}{"cat", "dog"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v but got %v", expected, result)
+	}
+
+	s, _ = NewS("pets", 0, Wildcard)
+	result, _ = s.Execute(source)
+	expected = []interface{}{2, "cat"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v but got %v", expected, result)
+	}
+
+	s, _ = NewS("owner", Wildcard)
+	if _, err := s.Execute(source); err == nil {
+		t.Errorf("Expected an error for the missing prefix")
+	}
+}
+
+func TestS_Execute_recursive_descent(t *testing.T) {
+	source := map[string]interface{}{
+		"name": "Bob",
+		"pets": []interface{}{
+			map[string]interface{}{"name": "cat", "toys": []interface{}{map[string]interface{}{"name": "ball"}}},
+			map[string]interface{}{"name": "dog"},
+		},
+	}
+
+	s, _ := NewS(RecursiveDescent, "name")
+	result, err := s.Execute(source)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %v", err)
+	}
+	expected := []interface{}{"Bob", "cat", "ball", "dog"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v but got %v", expected, result)
+	}
+
+	s, _ = NewS("pets", RecursiveDescent, "name")
+	result, _ = s.Execute(source)
+	expected = []interface{}{"cat", "ball", "dog"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v but got %v", expected, result)
+	}
+}
+
+func TestJMES_Execute(t *testing.T) {
+	source := map[interface{}]interface{}{
+		"name": "Bob",
+		"pets": []map[string]interface{}{
+			{"name": "dog", "age": 3},
+			{"name": "cat", "age": 2},
+			{"name": "bird", "age": 4},
+		},
+	}
+
+	testCases := []struct {
+		expression string
+		want       interface{}
+	}{
+		{"name", "Bob"},
+		{"pets[?age > `2`].name | sort(@)", []interface{}{"bird", "dog"}},
+		{"pets[*].age", []interface{}{3.0, 2.0, 4.0}},
+		{"{owner: name, count: length(pets)}", map[string]interface{}{"owner": "Bob", "count": 3.0}},
+		{"max_by(pets, &age).name", "bird"},
+		{"missing", nil},
+	}
+
+	for _, tc := range testCases {
+		j, err := NewJMES(tc.expression)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.expression, err)
+		}
+		got, err := j.Execute(source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.expression, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, but got %v", tc.expression, tc.want, got)
+		}
+	}
+
+	if _, err := NewJMES("pets[?age >"); err == nil {
+		t.Errorf("Expected a syntax error")
+	}
+}
+
+type testAddress struct {
+	City string `json:"city"`
+}
+
+type testAudit struct {
+	CreatedBy string `yaml:"created_by"`
+}
+
+type testUser struct {
+	*testAudit
+	UserID  int `json:"user_id,omitempty"`
+	Name    string
+	Address *testAddress `json:"address"`
+	Friends []*testUser  `json:"friends"`
+	secret  string
+}
+
+func TestS_Execute_struct(t *testing.T) {
+	user := &testUser{
+		testAudit: &testAudit{CreatedBy: "admin"},
+		UserID:    7,
+		Name:      "Bob",
+		Address:   &testAddress{City: "Paris"},
+		Friends:   []*testUser{{Name: "Ann", Address: &testAddress{City: "Rome"}}},
+		secret:    "x",
+	}
+	source := map[string]interface{}{"user": &user}
+
+	testCases := []struct {
+		path []interface{}
+		want interface{}
+	}{
+		{[]interface{}{"user", "user_id"}, 7},
+		{[]interface{}{"user", "UserID"}, 7},
+		{[]interface{}{"user", "Name"}, "Bob"},
+		{[]interface{}{"user", "address", "city"}, "Paris"},
+		{[]interface{}{"user", "friends", 0, "address", "city"}, "Rome"},
+		{[]interface{}{"user", "created_by"}, "admin"},
+		{[]interface{}{"user", "friends", Wildcard, "Name"}, []interface{}{"Ann"}},
+	}
+	for _, tc := range testCases {
+		s, _ := NewS(tc.path...)
+		result, err := s.Execute(source)
+		if err != nil {
+			t.Errorf("%v: unexpected error returned: %v", tc.path, err)
+		}
+		if !reflect.DeepEqual(result, tc.want) {
+			t.Errorf("%v: expected %v but got %v", tc.path, tc.want, result)
+		}
+	}
+
+	s, _ := NewS("user", "secret")
+	if _, err := s.Execute(source); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected a type mismatch for an unexported field, but got %v", err)
+	}
+	s, _ = NewS("user", "friends", 0, "created_by")
+	if _, err := s.Execute(source); err == nil {
+		t.Errorf("Expected an error for a field promoted through a nil pointer")
+	}
+	s, _ = NewS("user", "age")
+	if _, err := s.Execute(source); err == nil {
+		t.Errorf("Expected an error for a missing field")
+	}
+}
+
+type testInner struct {
+	X int
+}
+
+type testA struct {
+	testInner
+}
+
+type testB struct {
+	X int
+}
+
+type testOuter struct {
+	testA
+	testB
+}
+
+type testTie struct {
+	testB
+	testInner
+}
+
+type testKey string
+
+func TestS_Execute_struct_promotion(t *testing.T) {
+	// Go resolves O.X to the shallowest X: testB.X, not testA.testInner.X
+	outer := testOuter{testA: testA{testInner{X: 1}}, testB: testB{X: 2}}
+	s, _ := NewS("X")
+	if result, err := s.Execute(outer); err != nil || result != 2 {
+		t.Errorf("Expected 2 but got %v (%v)", result, err)
+	}
+	s, _ = NewS(testKey("X"))
+	if result, err := s.Execute(outer); err != nil || result != 2 {
+		t.Errorf("Expected 2 for a named string key but got %v (%v)", result, err)
+	}
+
+	// two X at the same depth hide each other
+	s, _ = NewS("X")
+	if _, err := s.Execute(testTie{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected an ambiguous field to be not found, but got %v", err)
+	}
+}
+
+func TestJMES_Execute_struct(t *testing.T) {
+	user := testUser{
+		testAudit: &testAudit{CreatedBy: "admin"},
+		UserID:    7,
+		Address:   &testAddress{City: "Paris"},
+	}
+	j, _ := NewJMES("[user_id, address.city, created_by]")
+	result, err := j.Execute(user)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %v", err)
+	}
+	expected := []interface{}{7.0, "Paris", "admin"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v but got %v", expected, result)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -32,6 +33,10 @@ func IsValidMatch(v reflect.Value, key reflect.Value) bool {
 		// check whether the type of the key is the same as that of the map key
 		return key.Type().AssignableTo(mapKeyType)
 	}
+	// check whether v is a struct addressed by field name
+	if v.Kind() == reflect.Struct {
+		return key.Kind() == reflect.String
+	}
 	// check whether v is an array
 	if v.Kind() == reflect.Array || v.Kind() == reflect.Slice {
 
@@ -52,6 +57,82 @@ func IsValidMatch(v reflect.Value, key reflect.Value) bool {
 	return false
 }
 
+// jsonPointer appends a key or index to a JSON pointer (RFC 6901) such as /spec/stars/2
+func jsonPointer(parent string, key interface{}) string {
+	token := strings.NewReplacer("~", "~0", "/", "~1").Replace(fmt.Sprint(key))
+	return parent + "/" + token
+}
+
+// sortedMapKeys returns the keys of a map in a stable order,
+// numbers are sorted by value and everything else by its string form
+func sortedMapKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		ki, iok := toFloat64(keys[i].Interface())
+		kj, jok := toFloat64(keys[j].Interface())
+		if iok && jok {
+			return ki < kj
+		}
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	return keys
+}
+
+// toJSONValue converts any map, slice, array or struct into the plain values produced by encoding/json:
+// map[string]interface{}, []interface{}, float64, string, bool and nil
+func toJSONValue(v reflect.Value) interface{} {
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr, reflect.Interface:
+		// a nil pointer or interface left by indirect
+		return nil
+	case reflect.Map:
+		result := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			result[fmt.Sprint(key.Interface())] = toJSONValue(v.MapIndex(key))
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result[i] = toJSONValue(v.Index(i))
+		}
+		return result
+	case reflect.Struct:
+		result := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() && !field.Anonymous {
+				continue
+			}
+			value := toJSONValue(v.Field(i))
+			// fields of an untagged embedded struct are promoted like encoding/json does
+			if promoted, ok := value.(map[string]interface{}); ok && field.Anonymous && fieldKey(field) == field.Name {
+				for k, pv := range promoted {
+					if _, shadowed := result[k]; !shadowed {
+						result[k] = pv
+					}
+				}
+				continue
+			}
+			if field.IsExported() {
+				result[fieldKey(field)] = value
+			}
+		}
+		return result
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	}
+	if f, ok := toFloat64(v.Interface()); ok {
+		return f
+	}
+	return v.Interface()
+}
+
 // Top level function
 // Analytical expression and execution
 // err is not nil if an error occurs (including arithmetic runtime errors)
@@ -68,12 +149,8 @@ func ParseAndExec(s string) (r float64, err error) {
 	if ast.Err != nil {
 		return 0, ast.Err
 	}
-	defer func() {
-		if e := recover(); e != nil {
-			err = e.(error)
-		}
-	}()
-	return ExprASTResult(ar).(float64), err
+	r, err = exprFloat(ar, nil, rootEnv(nil))
+	return r, withSource(err, s)
 }
 
 func ErrPos(s string, pos int) string {
@@ -91,12 +168,21 @@ func Pow(x float64, n float64) float64 {
 	return math.Pow(x, n)
 }
 
-func expr2Radian(expr ExprAST) float64 {
-	r := ExprASTResult(expr).(float64)
+func expr2Radian(expr ExprAST, context interface{}, e env) (float64, error) {
+	r, err := exprFloat(expr, context, e)
 	if TrigonometricMode == AngleMode {
 		r = r / 180 * math.Pi
 	}
-	return r
+	return r, err
+}
+
+// exprFloat evaluates a function argument as a float64, any number kind is accepted
+func exprFloat(expr ExprAST, context interface{}, e env) (float64, error) {
+	r, err := evalExpr(expr, context, e)
+	if err != nil {
+		return 0, err
+	}
+	return valueOf(r).float()
 }
 
 // Float64ToStr float64 -> string
@@ -122,180 +208,162 @@ func RegFunction(name string, argc int, fun func(...ExprAST) float64) error {
 	if _, ok := defFunc[name]; ok {
 		return errors.New("RegFunction name is already exist")
 	}
-	defFunc[name] = defS{argc, fun}
+	defFunc[name] = defS{argc, func(context interface{}, e env, expr ...ExprAST) (r float64, err error) {
+		// handlers written for ExprASTResult report failures by panicking
+		defer recoverError(&err)
+		return fun(expr...), nil
+	}}
 	return nil
 }
 
 // ExprASTResult is a Top level function
 // AST traversal
-// if an arithmetic runtime error occurs, a panic exception is thrown
+// if an arithmetic runtime error occurs, a panic exception is thrown,
+// use ExprASTResultWithContext to get it as an error instead
 func ExprASTResult(expr ExprAST) interface{} {
-	var l, r interface{}
-
-	//TODO: handle error and return a uniform result type
-	fmt.Printf("ExprASTResult-->%v\n", expr)
+	r, err := ExprASTResultWithContext(expr, nil)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
 
-	switch expr.(type) {
-	case BinaryExprAST:
-		ast := expr.(BinaryExprAST)
-		l = ExprASTResult(ast.Lhs)
-		r = ExprASTResult(ast.Rhs)
-		switch ast.Op {
-		case "+":
-			// strconv.Atoi(l)
-			switch v := l.(type) {
-			case int64, int, int16:
-				il := l.(int64)
-				ir := r.(int64)
-				return il + ir
-			case float64:
-				fl := l.(float64)
-				fr := r.(float64)
-				return fl + fr
-			case string:
-				sl := l.(string)
-				sr := r.(string)
-				return sl + sr
-			default:
-				panic(fmt.Sprintf("unsupported type %T in addition operation", v))
-			}
+// ExprASTResultWithContext evaluates an expression against a source, selectors read from context
+// and `$root` is context as well.
+// Operands are converted to value so every operator follows the same promotion rules,
+// failures are returned as an *EvalError located at the failing node and never panic
+func ExprASTResultWithContext(expr ExprAST, context interface{}) (interface{}, error) {
+	return evalExpr(expr, context, rootEnv(context))
+}
 
-		case "-":
-			fl := l.(float64)
-			fr := r.(float64)
-			return fl - fr
-		case "*":
-			fl := l.(float64)
-			fr := r.(float64)
-			return fl * fr
-		case "/":
-			if r.(float64) == 0 {
-				panic(errors.New(
-					fmt.Sprintf("violation of arithmetic specification: a division by zero in ExprASTResult: [%g/%g]",
-						l,
-						r)))
-			}
-			fl := l.(float64)
-			fr := r.(float64)
-			return fl / fr
-		case "%":
-			if r.(float64) == 0 {
-				panic(errors.New(
-					fmt.Sprintf("violation of arithmetic specification: a division by zero in ExprASTResult: [%g%%%g]",
-						l,
-						r)))
+// evalExpr evaluates an expression against context, its variables are looked up in e
+func evalExpr(expr ExprAST, context interface{}, e env) (interface{}, error) {
+	var t *Trace
+	if e.trace != nil {
+		t = &Trace{Node: expr.toStr()}
+		if sea, ok := expr.(SelectorExprAST); ok {
+			if s, ok := sea.Selector.(*S); ok {
+				t.Path = s.Path
 			}
-			il := int(l.(float64))
-			ir := int(r.(float64))
-			return il % ir
-		case "^":
-			fl := l.(float64)
-			fr := r.(float64)
-			res := Pow(fl, fr)
-			return res
-		default:
-			panic(fmt.Sprintf("unsupported operator %s", ast.Op))
-		}
-	case NumberExprAST:
-		return expr.(NumberExprAST).Val
-	case FunCallerExprAST:
-		f := expr.(FunCallerExprAST)
-		def := defFunc[f.Name]
-		return def.fun(f.Arg...)
-	case SelectorExprAST:
-		sea := expr.(SelectorExprAST)
-		// r, _ := sea.Selector.Execute(nil)
-		// fmt.Printf("%v-->%v\n", r, reflect.TypeOf(sea.Selector))
-		// return r
-		var r interface{}
-		switch v := sea.Selector.(type) {
-		case *K:
-			r, _ = sea.Selector.Execute(nil)
-		default:
-			panic(fmt.Sprintf("unsupported type %T in addition operation", v))
 		}
-		fmt.Printf("%v-->%v\n", r, reflect.TypeOf(sea.Selector))
-
-		return r
+		e = e.traced(t)
 	}
-
-	return nil
+	r, err := exprResult(expr, context, e)
+	if _, whole := expr.(SelectorExprAST); !whole && errors.Is(err, errOmitted) {
+		r, err = nil, omittedOperand(err)
+	}
+	if t != nil {
+		t.Value, t.Err = r, err
+	}
+	return r, err
 }
 
-func ExprASTResultWithContext(expr ExprAST, context interface{}) (interface{}, error) {
-	var l, r interface{}
-	// var err error
-	//TODO: handle error and return a uniform result type
-	// fmt.Printf("ExprASTResult-->%v\n", expr)
+// omittedOperand reports an IF without else branch used as an operand: it may drop
+// a whole entry of a mapping, but an operator has nothing to work on
+func omittedOperand(err error) error {
+	located := &EvalError{}
+	errors.As(err, &located)
+	return &EvalError{
+		Source: located.Source,
+		Offset: located.Offset,
+		Err:    errorOf(ErrNotFound, "IF without else branch has no value to use as an operand"),
+	}
+}
 
+func exprResult(expr ExprAST, context interface{}, e env) (interface{}, error) {
 	switch expr.(type) {
 	case BinaryExprAST:
 		ast := expr.(BinaryExprAST)
-		l, _ = ExprASTResultWithContext(ast.Lhs, context)
-		r, _ = ExprASTResultWithContext(ast.Rhs, context)
-		switch ast.Op {
-		case "+":
-			// strconv.Atoi(l)
-			switch v := l.(type) {
-			case int64, int, int16:
-				il := l.(int64)
-				ir := r.(int64)
-				return il + ir, nil
-			case float64, float32:
-				fl := l.(float64)
-				fr := r.(float64)
-				return fl + fr, nil
-			case string:
-				sl := l.(string)
-				sr := r.(string)
-				return sl + sr, nil
-			default:
-				panic(fmt.Sprintf("unsupported type %T in addition operation", v))
-			}
-
-		case "-":
-			fl := l.(float64)
-			fr := r.(float64)
-			return fl - fr, nil
-		case "*":
-			fl := l.(float64)
-			fr := r.(float64)
-			return fl * fr, nil
-		case "/":
-			if r.(float64) == 0 {
-				errMsg := fmt.Sprintf("violation of arithmetic specification: a division by zero in ExprASTResult: [%g/%g]", l, r)
-				return nil, errors.New(errMsg)
-			}
-			fl := l.(float64)
-			fr := r.(float64)
-			return fl / fr, nil
-		case "%":
-			if r.(float64) == 0 {
-				errMsg := fmt.Sprintf("violation of arithmetic specification: a division by zero in ExprASTResult: [%g/%g]", l, r)
-				return nil, errors.New(errMsg)
+		if ast.Op == "&&" || ast.Op == "||" {
+			return logicalResultWithContext(ast, context, e)
+		}
+		if ast.Op == "??" {
+			l, err := evalExpr(ast.Lhs, context, e)
+			if (err == nil && l != nil) || (err != nil && !errors.Is(err, ErrNotFound)) {
+				return l, err
 			}
-			il := int(l.(float64))
-			ir := int(r.(float64))
-			return il % ir, nil
-		case "^":
-			fl := l.(float64)
-			fr := r.(float64)
-			res := Pow(fl, fr)
-			return res, nil
-		default:
-			panic(fmt.Sprintf("unsupported operator %s", ast.Op))
+			return evalExpr(ast.Rhs, context, e)
+		}
+		l, err := evalExpr(ast.Lhs, context, e)
+		if err != nil {
+			return nil, err
+		}
+		r, err := evalExpr(ast.Rhs, context, e)
+		if err != nil {
+			return nil, err
+		}
+		switch ast.Op {
+		case "==", "!=", "<", "<=", ">", ">=":
+			b, err := compare(ast.Op, valueOf(l), valueOf(r))
+			return b, evalError(err, ast.Offset)
+		}
+		v, err := arithmetic(ast.Op, valueOf(l), valueOf(r))
+		if err != nil {
+			return nil, evalError(err, ast.Offset)
+		}
+		return v.Interface(), nil
+	case TernaryExprAST:
+		t := expr.(TernaryExprAST)
+		c, err := evalExpr(t.Cond, context, e)
+		if err != nil {
+			return nil, err
+		}
+		cond, err := toBool("?", c)
+		if err != nil {
+			return nil, evalError(err, t.Offset)
+		}
+		if cond {
+			e.trace.setBranch("then")
+			return evalExpr(t.Then, context, e)
+		}
+		e.trace.setBranch("else")
+		return evalExpr(t.Else, context, e)
+	case UnaryExprAST:
+		u := expr.(UnaryExprAST)
+		v, err := evalExpr(u.Operand, context, e)
+		if err != nil {
+			return nil, err
 		}
+		b, err := toBool(u.Op, v)
+		if err != nil {
+			return nil, evalError(err, u.Offset)
+		}
+		return !b, nil
 	case NumberExprAST:
-		return expr.(NumberExprAST).Val, nil
+		return literalValue(expr.(NumberExprAST)).Interface(), nil
+	case BoolExprAST:
+		return expr.(BoolExprAST).Val, nil
+	case NullExprAST:
+		return nil, nil
 	case FunCallerExprAST:
 		f := expr.(FunCallerExprAST)
-		def := defFunc[f.Name]
-		return def.fun(f.Arg...), nil
+		def, ok := defFunc[f.Name]
+		if !ok {
+			return nil, evalError(fmt.Errorf("function `%s` is undefined", f.Name), f.Offset)
+		}
+		r, err := def.fun(context, e, f.Arg...)
+		if err != nil {
+			return nil, evalError(fmt.Errorf("function `%s`: %w", f.Name, err), f.Offset)
+		}
+		return r, nil
 	case SelectorExprAST:
 		sea := expr.(SelectorExprAST)
-		// var r interface{}
-		r, err := sea.Selector.Execute(context)
-		return r, err
+		if sea.Selector == nil {
+			return nil, evalError(fmt.Errorf("Selector `%s` is empty", sea.Name), sea.Offset)
+		}
+		r, err := executeNode(sea.Selector, context, e)
+		return r, evalError(err, sea.Offset)
+	case VariableExprAST:
+		v := expr.(VariableExprAST)
+		val, ok := e.scope.lookup(v.Name)
+		if !ok {
+			return nil, evalError(errorOf(ErrNotFound, "variable %s is not bound", v.Name), v.Offset)
+		}
+		if len(v.Path) == 0 {
+			return val, nil
+		}
+		r, err := (&S{Path: v.Path}).Execute(val)
+		return r, evalError(err, v.Offset)
 	case StrExprAST:
 		return expr.(StrExprAST).Str, nil
 
@@ -303,3 +371,47 @@ func ExprASTResultWithContext(expr ExprAST, context interface{}) (interface{}, e
 
 	return nil, fmt.Errorf("Unsupported Expression AST %s", expr)
 }
+
+// logicalResultWithContext evaluates `&&` and `||` with short-circuiting,
+// the right operand is only evaluated when the left one does not decide the result
+func logicalResultWithContext(ast BinaryExprAST, context interface{}, e env) (interface{}, error) {
+	l, err := evalExpr(ast.Lhs, context, e)
+	if err != nil {
+		return nil, err
+	}
+	lb, err := toBool(ast.Op, l)
+	if err != nil {
+		return nil, evalError(err, ast.Offset)
+	}
+	if (ast.Op == "&&" && !lb) || (ast.Op == "||" && lb) {
+		return lb, nil
+	}
+	r, err := evalExpr(ast.Rhs, context, e)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := toBool(ast.Op, r)
+	return rb, evalError(err, ast.Offset)
+}
+
+func toBool(op string, v interface{}) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, errorOf(ErrTypeMismatch, "operator %s wants a bool operand but get %T", op, v)
+	}
+	return b, nil
+}
+
+// toFloat64 reports the value of any Go numeric type as float64
+func toFloat64(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
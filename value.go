@@ -0,0 +1,301 @@
+package whiteboard
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// valueKind classifies the values the evaluator works with
+type valueKind int
+
+const (
+	nullKind valueKind = iota
+	boolKind
+	intKind
+	floatKind
+	stringKind
+	listKind
+	mapKind
+	timeKind
+	// any other Go value, it only supports `==` and `!=`
+	opaqueKind
+)
+
+var valueKindNames = map[valueKind]string{
+	nullKind:   "null",
+	boolKind:   "bool",
+	intKind:    "int",
+	floatKind:  "float",
+	stringKind: "string",
+	listKind:   "list",
+	mapKind:    "map",
+	timeKind:   "time",
+	opaqueKind: "opaque",
+}
+
+func (k valueKind) String() string {
+	return valueKindNames[k]
+}
+
+// value is the uniform representation of an operand.
+// Every Go integer type is held as int64, every float type as float64, slices and arrays are lists,
+// maps and structs are maps, and the original Go value is kept for lists, maps and opaque values.
+//
+// Numeric promotion rules:
+//
+//	int   + - * %   int   -> int
+//	int   + - * %   float -> float
+//	any   /         any   -> float
+//	any   ^         any   -> float
+//
+// `+` also concatenates two strings or two lists. Every other combination, as well as dividing by zero,
+// is a type mismatch reported as an error
+type value struct {
+	kind valueKind
+	i    int64
+	f    float64
+	s    string
+	b    bool
+	t    time.Time
+	raw  interface{}
+}
+
+var nullValue = value{kind: nullKind}
+
+func intValue(i int64) value {
+	return value{kind: intKind, i: i}
+}
+
+func floatValue(f float64) value {
+	return value{kind: floatKind, f: f}
+}
+
+func valueOf(x interface{}) value {
+	switch v := x.(type) {
+	case nil:
+		return nullValue
+	case value:
+		return v
+	case time.Time:
+		return value{kind: timeKind, t: v}
+	}
+
+	rv := indirect(reflect.ValueOf(x))
+	switch rv.Kind() {
+	case reflect.Invalid, reflect.Ptr, reflect.Interface:
+		// nil pointers and interfaces
+		return nullValue
+	case reflect.Bool:
+		return value{kind: boolKind, b: rv.Bool()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return intValue(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return floatValue(float64(rv.Uint()))
+		}
+		return intValue(int64(rv.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return floatValue(rv.Float())
+	case reflect.String:
+		return value{kind: stringKind, s: rv.String()}
+	case reflect.Slice, reflect.Array:
+		return value{kind: listKind, raw: x}
+	case reflect.Map:
+		return value{kind: mapKind, raw: x}
+	case reflect.Struct:
+		if t, ok := rv.Interface().(time.Time); ok {
+			return value{kind: timeKind, t: t}
+		}
+		return value{kind: mapKind, raw: x}
+	}
+	return value{kind: opaqueKind, raw: x}
+}
+
+// literalValue reads a number literal of the expression language,
+// integer literals are ints and anything with a fraction or an exponent is a float
+func literalValue(n NumberExprAST) value {
+	if i, err := strconv.ParseInt(n.Str, 10, 64); err == nil {
+		return intValue(i)
+	}
+	return floatValue(n.Val)
+}
+
+// Interface converts the value back to a plain Go value
+func (v value) Interface() interface{} {
+	switch v.kind {
+	case nullKind:
+		return nil
+	case boolKind:
+		return v.b
+	case intKind:
+		return v.i
+	case floatKind:
+		return v.f
+	case stringKind:
+		return v.s
+	case timeKind:
+		return v.t
+	}
+	return v.raw
+}
+
+// truthy is the truth value of a condition: null, false, zero numbers, empty strings,
+// empty lists and empty maps are false, everything else is true
+func (v value) truthy() bool {
+	switch v.kind {
+	case nullKind:
+		return false
+	case boolKind:
+		return v.b
+	case intKind:
+		return v.i != 0
+	case floatKind:
+		return v.f != 0
+	case stringKind:
+		return v.s != ""
+	case listKind, mapKind:
+		rv := indirect(reflect.ValueOf(v.raw))
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array || rv.Kind() == reflect.Map {
+			return rv.Len() != 0
+		}
+	}
+	return true
+}
+
+func (v value) isNumber() bool {
+	return v.kind == intKind || v.kind == floatKind
+}
+
+// float reads any number as float64
+func (v value) float() (float64, error) {
+	switch v.kind {
+	case intKind:
+		return float64(v.i), nil
+	case floatKind:
+		return v.f, nil
+	}
+	return 0, errorOf(ErrTypeMismatch, "want a number but get %s", v.kind)
+}
+
+// list returns the elements of a list value
+func (v value) list() []interface{} {
+	rv := indirect(reflect.ValueOf(v.raw))
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items
+}
+
+// arithmetic applies + - * / % ^ following the numeric promotion rules
+func arithmetic(op string, l, r value) (value, error) {
+	if op == "+" {
+		switch {
+		case l.kind == stringKind && r.kind == stringKind:
+			return value{kind: stringKind, s: l.s + r.s}, nil
+		case l.kind == listKind && r.kind == listKind:
+			items := append(l.list(), r.list()...)
+			return value{kind: listKind, raw: items}, nil
+		}
+	}
+	if !l.isNumber() || !r.isNumber() {
+		return nullValue, errorOf(ErrTypeMismatch, "unsupported operand types for %s: %s and %s", op, l.kind, r.kind)
+	}
+
+	if l.kind == intKind && r.kind == intKind {
+		switch op {
+		case "+":
+			return intValue(l.i + r.i), nil
+		case "-":
+			return intValue(l.i - r.i), nil
+		case "*":
+			return intValue(l.i * r.i), nil
+		case "%":
+			if r.i == 0 {
+				return nullValue, errorOf(ErrDivisionByZero, "violation of arithmetic specification: division by zero: [%d%%%d]", l.i, r.i)
+			}
+			return intValue(l.i % r.i), nil
+		}
+	}
+
+	fl, _ := l.float()
+	fr, _ := r.float()
+	switch op {
+	case "+":
+		return floatValue(fl + fr), nil
+	case "-":
+		return floatValue(fl - fr), nil
+	case "*":
+		return floatValue(fl * fr), nil
+	case "/":
+		if fr == 0 {
+			return nullValue, errorOf(ErrDivisionByZero, "violation of arithmetic specification: division by zero: [%g/%g]", fl, fr)
+		}
+		return floatValue(fl / fr), nil
+	case "%":
+		if fr == 0 {
+			return nullValue, errorOf(ErrDivisionByZero, "violation of arithmetic specification: division by zero: [%g%%%g]", fl, fr)
+		}
+		return floatValue(math.Mod(fl, fr)), nil
+	case "^":
+		return floatValue(Pow(fl, fr)), nil
+	}
+	return nullValue, fmt.Errorf("unsupported operator %s", op)
+}
+
+// compare applies == != < <= > >=.
+// Numbers compare by value whatever their kind, strings lexically and times chronologically,
+// every other kind only supports equality
+func compare(op string, l, r value) (bool, error) {
+	var c int
+	switch {
+	case l.kind == intKind && r.kind == intKind:
+		c = compareOrdered(l.i, r.i)
+	case l.isNumber() && r.isNumber():
+		fl, _ := l.float()
+		fr, _ := r.float()
+		c = compareOrdered(fl, fr)
+	case l.kind == stringKind && r.kind == stringKind:
+		c = compareOrdered(l.s, r.s)
+	case l.kind == timeKind && r.kind == timeKind:
+		c = l.t.Compare(r.t)
+	default:
+		equal := l.kind == r.kind && reflect.DeepEqual(l.Interface(), r.Interface())
+		switch op {
+		case "==":
+			return equal, nil
+		case "!=":
+			return !equal, nil
+		}
+		return false, errorOf(ErrTypeMismatch, "unsupported comparison %s %s %s", l.kind, op, r.kind)
+	}
+
+	switch op {
+	case "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unsupported operator %s", op)
+}
+
+func compareOrdered[T int64 | float64 | string](l, r T) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
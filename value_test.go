@@ -0,0 +1,106 @@
+package whiteboard
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Value_Arithmetic(t *testing.T) {
+	type score int32
+	source := map[string]interface{}{
+		"age":    20,
+		"small":  uint8(3),
+		"score":  score(7),
+		"ratio":  float32(0.5),
+		"name":   "Bob",
+		"tags":   []string{"a"},
+		"joined": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		"left":   time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	testCases := []struct {
+		exp  string
+		want interface{}
+	}{
+		{"S(\"age\") + 1", int64(21)},
+		{"S(\"age\") + 1.5", 21.5},
+		{"S(\"age\") * S(\"small\") - S(\"score\")", int64(53)},
+		{"S(\"age\") / 8", 2.5},
+		{"S(\"age\") % 6", int64(2)},
+		{"S(\"age\") % 6.5", 0.5},
+		{"S(\"age\") * S(\"ratio\")", 10.0},
+		{"2 ^ 3", 8.0},
+		{"-S(\"age\")", int64(-20)},
+		{"1e2 + 1", 101.0},
+		{"S(\"name\") + \"!\"", "Bob!"},
+		{"S(\"tags\") + S(\"tags\")", []interface{}{"a", "a"}},
+		{"S(\"age\") == 20.0", true},
+		{"S(\"small\") < S(\"ratio\") * 10", true},
+		{"S(\"joined\") < S(\"left\")", true},
+		{"S(\"name\") == 1", false},
+		{"S(\"tags\") != S(\"tags\")", false},
+	}
+
+	for _, tc := range testCases {
+		toks, err := Parse(tc.exp)
+		if err != nil {
+			t.Fatalf("%s: unexpected lexical error: %v", tc.exp, err)
+		}
+		ast := NewAST(toks, tc.exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", tc.exp, ast.Err)
+		}
+		r, err := ExprASTResultWithContext(ar, source)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+		}
+		if !reflect.DeepEqual(r, tc.want) {
+			t.Errorf("%s: expected %v (%T), but got %v (%T)", tc.exp, tc.want, tc.want, r, r)
+		}
+	}
+}
+
+func Test_Value_Type_Mismatch(t *testing.T) {
+	source := map[string]interface{}{"age": 20, "name": "Bob", "ok": true}
+	for _, exp := range []string{
+		"S(\"name\") + 1",
+		"S(\"ok\") * 2",
+		"S(\"age\") - null",
+		"S(\"name\") < 1",
+		"S(\"age\") % 0",
+		"S(\"age\") / 0",
+	} {
+		toks, _ := Parse(exp)
+		ast := NewAST(toks, exp)
+		ar := ast.ParseExpression()
+		if ast.Err != nil {
+			t.Fatalf("%s: unexpected syntax error: %v", exp, ast.Err)
+		}
+		if _, err := ExprASTResultWithContext(ar, source); err == nil {
+			t.Errorf("%s: expected an error", exp)
+		}
+	}
+}
+
+func Test_Value_Functions(t *testing.T) {
+	testCases := []struct {
+		exp  string
+		want float64
+	}{
+		{"abs(-2)", 2},
+		{"max(1, 2.5, 2)", 2.5},
+		{"round(pi)", 3},
+		{"7 / 2", 3.5},
+		{"noerr(1 / 0)", 0},
+	}
+	for _, tc := range testCases {
+		r, err := ParseAndExec(tc.exp)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.exp, err)
+		}
+		if r != tc.want {
+			t.Errorf("%s: expected %v, but got %v", tc.exp, tc.want, r)
+		}
+	}
+}